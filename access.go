@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	AccessFile   = "access.json" // Заблокированные пользователи и режим обслуживания
+	AuditLogFile = "audit.jsonl" // Журнал действий админов
+)
+
+// DefaultMaintenanceMessage ответ пользователям в режиме обслуживания
+const DefaultMaintenanceMessage = "Бот на обслуживании, команды временно не работают. Напоминания о парах приходят как обычно"
+
+// Ban запись о заблокированном пользователе или чате
+type Ban struct {
+	ID     int64     `json:"id"`
+	At     time.Time `json:"at"`
+	By     int64     `json:"by"` // Кто из админов заблокировал
+	Reason string    `json:"reason,omitempty"`
+}
+
+// accessState содержимое access.json
+type accessState struct {
+	Banned             []Ban  `json:"banned"`
+	Maintenance        bool   `json:"maintenance"`
+	MaintenanceMessage string `json:"maintenance_message,omitempty"`
+}
+
+// AccessStore хранит блокировки и режим обслуживания. В отличие от
+// подписок, переживает /stop и повторный /start
+type AccessStore struct {
+	mu       sync.Mutex
+	filename string
+	state    accessState
+	banned   map[int64]Ban
+}
+
+// NewAccessStore создает хранилище поверх файла filename
+func NewAccessStore(filename string) *AccessStore {
+	return &AccessStore{filename: filename, banned: make(map[int64]Ban)}
+}
+
+// Load читает состояние из файла. Отсутствующий файл - не ошибка
+func (s *AccessStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state accessState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("ошибка парсинга %s: %w", s.filename, err)
+	}
+
+	s.state = state
+	s.banned = make(map[int64]Ban, len(state.Banned))
+	for _, ban := range state.Banned {
+		s.banned[ban.ID] = ban
+	}
+	return nil
+}
+
+// save сохраняет состояние на диск. Вызывается под s.mu
+func (s *AccessStore) save() error {
+	s.state.Banned = s.bans()
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filename, data, 0644)
+}
+
+// bans возвращает блокировки, отсортированные по ID. Вызывается под s.mu
+func (s *AccessStore) bans() []Ban {
+	list := make([]Ban, 0, len(s.banned))
+	for _, ban := range s.banned {
+		list = append(list, ban)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// IsBanned проверяет, заблокирован ли хотя бы один из ID
+func (s *AccessStore) IsBanned(ids ...int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := s.banned[id]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Banned возвращает все блокировки
+func (s *AccessStore) Banned() []Ban {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bans()
+}
+
+// Ban блокирует пользователя или чат. Повторная блокировка обновляет причину
+func (s *AccessStore) Ban(ban Ban) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.banned[ban.ID] = ban
+	return s.save()
+}
+
+// Unban снимает блокировку и сообщает, была ли она
+func (s *AccessStore) Unban(id int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.banned[id]; !ok {
+		return false, nil
+	}
+	delete(s.banned, id)
+	return true, s.save()
+}
+
+// Maintenance возвращает, включен ли режим обслуживания, и ответ пользователям
+func (s *AccessStore) Maintenance() (bool, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message := s.state.MaintenanceMessage
+	if message == "" {
+		message = DefaultMaintenanceMessage
+	}
+	return s.state.Maintenance, message
+}
+
+// SetMaintenance включает или выключает режим обслуживания. Пустой
+// message - ответ по умолчанию
+func (s *AccessStore) SetMaintenance(on bool, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Maintenance = on
+	s.state.MaintenanceMessage = message
+	return s.save()
+}
+
+// auditRecord запись журнала действий админов
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	AdminID int64     `json:"admin_id"`
+	ChatID  int64     `json:"chat_id"`
+	Command string    `json:"command"`
+	Args    string    `json:"args,omitempty"`
+	Result  string    `json:"result"`
+}
+
+// audit дописывает действие админа в журнал audit.jsonl и в лог. Пишутся
+// и отказы: попытки выполнить команду админа без прав
+func (bot *TimetableBot) audit(cmd adminCommand, result string) {
+	record := auditRecord{
+		Time:    time.Now(),
+		AdminID: cmd.From,
+		ChatID:  cmd.ChatID,
+		Command: cmd.Name,
+		Args:    cmd.Text,
+		Result:  result,
+	}
+	slog.Info("🛡️ Команда админа", "user_id", record.AdminID, "chat_id", record.ChatID,
+		"command", record.Command, "args", record.Args, "result", record.Result)
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	file, err := os.OpenFile(bot.auditLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		slog.Warn("⚠️ Не удалось записать в журнал действий", "file", bot.auditLog, "err", err)
+		return
+	}
+	defer file.Close()
+
+	file.Write(append(data, '\n'))
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const adminCommandsHelp = "🛠 <b>Команды админа:</b>\n" +
+	"/refresh - загрузить расписание с сайта сейчас\n" +
+	"/broadcast текст - отправить сообщение всем подписчикам\n" +
+	"/users [страница] - подписчики, /users banned - заблокированные\n" +
+	"/ban ID [причина] - заблокировать пользователя или чат, /unban ID - разблокировать\n" +
+	"/queue - очередь исходящих сообщений\n" +
+	"/maintenance on [текст]|off - режим обслуживания\n\n" +
+	"Все команды админа записываются в журнал " + AuditLogFile
+
+// Подписчиков на одной странице /users
+const usersPageSize = 30
+
+// adminCommand команда админа вместе с тем, кто и откуда ее отправил
+type adminCommand struct {
+	From   int64 // Пользователь
+	ChatID int64 // Куда отвечать
+	Name   string
+	Args   []string
+	Text   string // Текст после команды как есть, с переносами строк
+}
+
+// adminCommands команды, доступные только владельцу и ADMINS. Обработчик
+// отвечает в чат сам и возвращает итог для журнала действий
+var adminCommands = map[string]func(*TimetableBot, adminCommand) string{
+	"/admin":       (*TimetableBot).adminHelp,
+	"/refresh":     (*TimetableBot).adminRefresh,
+	"/broadcast":   (*TimetableBot).adminBroadcast,
+	"/users":       (*TimetableBot).adminUsers,
+	"/ban":         (*TimetableBot).adminBan,
+	"/unban":       (*TimetableBot).adminUnban,
+	"/queue":       (*TimetableBot).adminQueue,
+	"/maintenance": (*TimetableBot).adminMaintenance,
+}
+
+// handleAdminCommand проверяет права и выполняет команду админа
+func (bot *TimetableBot) handleAdminCommand(cmd adminCommand) {
+	if !bot.isAdmin(cmd.From) {
+		bot.audit(cmd, "отказано")
+		bot.SendMessageToChat(cmd.ChatID, "⛔ Команда доступна только админам")
+		return
+	}
+	bot.audit(cmd, adminCommands[cmd.Name](bot, cmd))
+}
+
+// isAdmin проверяет, что пользователь - владелец бота (USER_ID) или есть в ADMINS
+func (bot *TimetableBot) isAdmin(userID int64) bool {
+	if owner, err := strconv.ParseInt(bot.userID, 10, 64); err == nil && userID == owner {
+		return true
+	}
+	for _, admin := range bot.runtime().config.Admins {
+		if userID == admin {
+			return true
+		}
+	}
+	return false
+}
+
+func (bot *TimetableBot) adminHelp(cmd adminCommand) string {
+	bot.SendMessageToChat(cmd.ChatID, adminCommandsHelp)
+	return "ok"
+}
+
+// adminRefresh загружает расписание в фоне и сообщает результат
+func (bot *TimetableBot) adminRefresh(cmd adminCommand) string {
+	bot.SendMessageToChat(cmd.ChatID, "🔄 Загружаю расписание с сайта...")
+	bot.goBackground(func() {
+		if err := bot.UpdateSchedule(); err != nil {
+			bot.SendMessageToChat(cmd.ChatID, "❌ Не удалось обновить расписание:\n<code>"+html.EscapeString(err.Error())+"</code>")
+			return
+		}
+		bot.SendMessageToChat(cmd.ChatID, fmt.Sprintf("✅ Расписание обновлено: %d пар", len(bot.Schedule())))
+	})
+	return "запущено"
+}
+
+func (bot *TimetableBot) adminBroadcast(cmd adminCommand) string {
+	text := strings.TrimSpace(cmd.Text)
+	if text == "" {
+		bot.SendMessageToChat(cmd.ChatID, "Использование: /broadcast текст сообщения")
+		return "нет текста"
+	}
+
+	message := "📣 " + html.EscapeString(text)
+	queued := 0
+	for _, chat := range bot.subscribers.All() {
+		if bot.access.IsBanned(chat.ChatID) {
+			continue
+		}
+		if bot.SendMessageToChat(chat.ChatID, message) == nil {
+			metricNotificationsQueued.Inc("broadcast")
+			queued++
+		}
+	}
+
+	bot.SendMessageToChat(cmd.ChatID, fmt.Sprintf("📣 Рассылка в очереди: %d чатов. Ход отправки - /queue", queued))
+	return fmt.Sprintf("в очереди для %d чатов", queued)
+}
+
+func (bot *TimetableBot) adminUsers(cmd adminCommand) string {
+	if len(cmd.Args) == 1 && cmd.Args[0] == "banned" {
+		bot.SendMessageToChat(cmd.ChatID, bot.formatBans())
+		return "ok"
+	}
+
+	page := 1
+	if len(cmd.Args) == 1 {
+		n, err := strconv.Atoi(cmd.Args[0])
+		if err != nil || n < 1 {
+			bot.SendMessageToChat(cmd.ChatID, "Использование: /users [страница] или /users banned")
+			return "неверные аргументы"
+		}
+		page = n
+	}
+
+	subscribers := bot.subscribers.All()
+	pages := (len(subscribers) + usersPageSize - 1) / usersPageSize
+	if pages == 0 {
+		pages = 1
+	}
+	if page > pages {
+		page = pages
+	}
+	from := (page - 1) * usersPageSize
+	to := min(from+usersPageSize, len(subscribers))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "👥 <b>Подписчики</b>: %d\n\n", len(subscribers))
+	for _, chat := range subscribers[from:to] {
+		mark := ""
+		switch {
+		case bot.isAdmin(chat.ChatID):
+			mark = " 👑"
+		case chat.ChatID < 0:
+			mark = " 👥"
+		}
+		fmt.Fprintf(&b, "• <code>%d</code>%s за %d мин", chat.ChatID, mark, chat.LeadMinutes)
+		if len(chat.Filters) > 0 {
+			fmt.Fprintf(&b, ", фильтров: %d", len(chat.Filters))
+		}
+		b.WriteString("\n")
+	}
+	if pages > 1 {
+		fmt.Fprintf(&b, "\nСтраница %d из %d", page, pages)
+		if page < pages {
+			fmt.Fprintf(&b, ", дальше: /users %d", page+1)
+		}
+		b.WriteString("\n")
+	}
+	if banned := len(bot.access.Banned()); banned > 0 {
+		fmt.Fprintf(&b, "\n🚫 Заблокировано: %d, список: /users banned", banned)
+	}
+
+	bot.SendMessageToChat(cmd.ChatID, strings.TrimSpace(b.String()))
+	return "ok"
+}
+
+// formatBans форматирует список заблокированных
+func (bot *TimetableBot) formatBans() string {
+	bans := bot.access.Banned()
+	if len(bans) == 0 {
+		return "🚫 Заблокированных нет"
+	}
+
+	loc := bot.runtime().location
+	var b strings.Builder
+	fmt.Fprintf(&b, "🚫 <b>Заблокированы</b>: %d\n\n", len(bans))
+	for _, ban := range bans {
+		fmt.Fprintf(&b, "• <code>%d</code> с %s, админ %d", ban.ID, ban.At.In(loc).Format("02.01.2006 15:04"), ban.By)
+		if ban.Reason != "" {
+			fmt.Fprintf(&b, ": %s", html.EscapeString(ban.Reason))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\nРазблокировать: /unban ID")
+	return b.String()
+}
+
+// adminBan блокирует пользователя или чат: его команды игнорируются,
+// подписка удаляется, а неотправленные ему сообщения убираются из очереди
+func (bot *TimetableBot) adminBan(cmd adminCommand) string {
+	id, ok := parseChatIDArg(cmd.Args)
+	if !ok {
+		bot.SendMessageToChat(cmd.ChatID, "Использование: /ban ID [причина], ID - из /users")
+		return "неверные аргументы"
+	}
+	if bot.isAdmin(id) {
+		bot.SendMessageToChat(cmd.ChatID, "❌ Нельзя заблокировать админа, сначала убери его из ADMINS")
+		return "отказано: админ"
+	}
+
+	ban := Ban{ID: id, At: time.Now(), By: cmd.From, Reason: strings.Join(cmd.Args[1:], " ")}
+	if err := bot.access.Ban(ban); err != nil {
+		slog.Warn("⚠️ Не удалось сохранить блокировку", "file", AccessFile, "err", err)
+		bot.SendMessageToChat(cmd.ChatID, "❌ Не удалось сохранить блокировку: "+html.EscapeString(err.Error()))
+		return "ошибка: " + err.Error()
+	}
+	if err := bot.subscribers.Unsubscribe(id); err != nil {
+		slog.Warn("⚠️ Не удалось удалить подписчика", "chat_id", id, "err", err)
+	}
+	bot.outbox.dropChat(id)
+
+	bot.SendMessageToChat(cmd.ChatID, fmt.Sprintf("🚫 <code>%d</code> заблокирован: команды игнорируются, подписка удалена", id))
+	return "заблокирован"
+}
+
+func (bot *TimetableBot) adminUnban(cmd adminCommand) string {
+	id, ok := parseChatIDArg(cmd.Args)
+	if !ok || len(cmd.Args) != 1 {
+		bot.SendMessageToChat(cmd.ChatID, "Использование: /unban ID, список: /users banned")
+		return "неверные аргументы"
+	}
+
+	removed, err := bot.access.Unban(id)
+	if err != nil {
+		slog.Warn("⚠️ Не удалось сохранить блокировку", "file", AccessFile, "err", err)
+		bot.SendMessageToChat(cmd.ChatID, "❌ Не удалось снять блокировку: "+html.EscapeString(err.Error()))
+		return "ошибка: " + err.Error()
+	}
+	if !removed {
+		bot.SendMessageToChat(cmd.ChatID, fmt.Sprintf("<code>%d</code> не заблокирован", id))
+		return "не был заблокирован"
+	}
+
+	bot.SendMessageToChat(cmd.ChatID, fmt.Sprintf("✅ <code>%d</code> разблокирован. Подписаться снова можно через /start", id))
+	return "разблокирован"
+}
+
+func (bot *TimetableBot) adminQueue(cmd adminCommand) string {
+	pending := bot.outbox.Snapshot()
+	if len(pending) == 0 {
+		bot.SendMessageToChat(cmd.ChatID, "📤 Очередь пуста")
+		return "ok"
+	}
+
+	type chatQueue struct {
+		chatID   int64
+		messages int
+		attempts int // Больше всего попыток у одного сообщения
+	}
+	byChat := map[int64]*chatQueue{}
+	oldest := pending[0].Queued
+	for _, msg := range pending {
+		q := byChat[msg.ChatID]
+		if q == nil {
+			q = &chatQueue{chatID: msg.ChatID}
+			byChat[msg.ChatID] = q
+		}
+		q.messages++
+		q.attempts = max(q.attempts, msg.Attempts)
+		if !msg.Queued.IsZero() && msg.Queued.Before(oldest) {
+			oldest = msg.Queued
+		}
+	}
+	chats := make([]*chatQueue, 0, len(byChat))
+	for _, q := range byChat {
+		chats = append(chats, q)
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		if chats[i].messages != chats[j].messages {
+			return chats[i].messages > chats[j].messages
+		}
+		return chats[i].chatID < chats[j].chatID
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📤 <b>Очередь</b>: %d сообщений в %d чатов\n", len(pending), len(chats))
+	if !oldest.IsZero() {
+		fmt.Fprintf(&b, "⏳ Самое старое ждет %s\n", formatAge(time.Since(oldest)))
+	}
+	b.WriteString("\n")
+	for i, q := range chats {
+		if i == 10 {
+			fmt.Fprintf(&b, "... и еще %d чатов\n", len(chats)-i)
+			break
+		}
+		fmt.Fprintf(&b, "• <code>%d</code>: %d", q.chatID, q.messages)
+		if q.attempts > 0 {
+			fmt.Fprintf(&b, ", попыток: %d", q.attempts)
+		}
+		b.WriteString("\n")
+	}
+
+	bot.SendMessageToChat(cmd.ChatID, strings.TrimSpace(b.String()))
+	return "ok"
+}
+
+// adminMaintenance включает режим обслуживания: команды пользователей
+// получают короткий ответ вместо выполнения, напоминания о парах идут как обычно
+func (bot *TimetableBot) adminMaintenance(cmd adminCommand) string {
+	if len(cmd.Args) == 0 {
+		on, message := bot.access.Maintenance()
+		if !on {
+			bot.SendMessageToChat(cmd.ChatID, "✅ Режим обслуживания выключен. Включить: /maintenance on [текст]")
+			return "ok"
+		}
+		bot.SendMessageToChat(cmd.ChatID, "🛠 Режим обслуживания включен, пользователи получают:\n"+html.EscapeString(message))
+		return "ok"
+	}
+
+	on, ok := parseSwitch(cmd.Args[:1])
+	if !ok {
+		bot.SendMessageToChat(cmd.ChatID, "Использование: /maintenance on [текст] или /maintenance off")
+		return "неверные аргументы"
+	}
+
+	message := ""
+	if on {
+		message = strings.Join(cmd.Args[1:], " ")
+	}
+	if err := bot.access.SetMaintenance(on, message); err != nil {
+		slog.Warn("⚠️ Не удалось сохранить режим обслуживания", "file", AccessFile, "err", err)
+		bot.SendMessageToChat(cmd.ChatID, "❌ Не удалось сохранить: "+html.EscapeString(err.Error()))
+		return "ошибка: " + err.Error()
+	}
+
+	if !on {
+		bot.SendMessageToChat(cmd.ChatID, "✅ Режим обслуживания выключен")
+		return "выключен"
+	}
+	_, message = bot.access.Maintenance()
+	bot.SendMessageToChat(cmd.ChatID, "🛠 Режим обслуживания включен, пользователи получат:\n"+html.EscapeString(message))
+	return "включен"
+}
+
+// parseChatIDArg разбирает ID пользователя или чата из первого аргумента
+func parseChatIDArg(args []string) (int64, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	return id, err == nil && id != 0
+}
@@ -0,0 +1,701 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"msuparser/parser"
+)
+
+const (
+	CheckInterval   = 1 * time.Minute
+	SubscribersFile = "subscribers.json"
+	ScheduleFile    = "schedule.json"
+)
+
+type TimetableBot struct {
+	// Задаются при запуске и дальше не меняются
+	telegram      *TelegramClient
+	outbox        *Outbox
+	userID        string
+	updatesMode   string
+	webhook       WebhookSettings
+	httpListen    string
+	metricsListen string
+	clock         Clock
+	configSource  *ConfigSource // Откуда перечитывать конфиг
+	username      string        // Имя бота из getMe, для команд вида /today@имя
+	auditLog      string        // Журнал действий админов
+
+	// Со своими блокировками
+	subscribers               *SubscriberStore
+	access                    *AccessStore   // Блокировки и режим обслуживания
+	reminders                 *ReminderStore // message_id напоминаний для правки при изменении пары
+	fetchHealth               *FetchHealth
+	sentNotifications         *sentSet // Ключ: чат + пара
+	sentDistanceNotifications *sentSet // Трекинг дистанционных уведомлений по чату и дате
+
+	scheduleMu sync.RWMutex
+	schedule   *parser.Schedule // Заменяется целиком, опубликованная выгрузка не меняется
+
+	runtimeMu  sync.RWMutex
+	runtimeCfg *runtimeConfig // Заменяется целиком в applyConfig
+
+	// Загрузка расписания с сайта и перечитывание файлов идут по одному
+	updateMu           sync.Mutex
+	scheduleWatcher    *fileWatcher // Замечают правки schedule.json и config.json снаружи
+	configWatcher      *fileWatcher
+	fetchStatusWatcher *fileWatcher // Итог запусков команды fetch
+
+	lastUpdateRun time.Time // Когда последний раз запускалось обновление в 2:00, только в планировщике
+
+	startedAt time.Time
+	heartbeat atomic.Int64     // Последний цикл планировщика (UnixNano), для /healthz
+	errors    *subsystemErrors // Последние ошибки подсистем для /status
+
+	background sync.WaitGroup // Горутины, которые shutdown ждет перед выходом
+}
+
+func NewTimetableBot(telegram *TelegramClient, userID string) *TimetableBot {
+	bot := &TimetableBot{
+		telegram:                  telegram,
+		userID:                    userID,
+		updatesMode:               UpdatesModePolling,
+		clock:                     systemClock{},
+		schedule:                  &parser.Schedule{Lessons: []parser.Lesson{}},
+		subscribers:               NewSubscriberStore(SubscribersFile),
+		access:                    NewAccessStore(AccessFile),
+		reminders:                 NewReminderStore(RemindersFile),
+		auditLog:                  AuditLogFile,
+		sentNotifications:         newSentSet(),
+		sentDistanceNotifications: newSentSet(),
+		fetchHealth:               NewFetchHealth(DefaultStaleAfter),
+		startedAt:                 time.Now(),
+		errors:                    newSubsystemErrors(),
+	}
+	bot.runtimeCfg = newRuntimeConfig(DefaultConfig(), nil, bot.clock)
+	bot.outbox = NewOutbox(telegram, DeadLettersFile, OutboxHooks{
+		ChatGone:   bot.handleChatGone,
+		Migrated:   bot.handleChatMigrated,
+		Thread:     bot.chatThread,
+		ThreadGone: bot.resetChatThread,
+		Sent:       bot.handleReminderSent,
+	})
+	return bot
+}
+
+// Schedule возвращает пары текущего расписания. Срез после публикации не
+// изменяется, поэтому его можно читать без блокировки
+func (bot *TimetableBot) Schedule() []parser.Lesson {
+	bot.scheduleMu.RLock()
+	defer bot.scheduleMu.RUnlock()
+	return bot.schedule.Lessons
+}
+
+// ScheduleModTime возвращает время, когда расписание было получено с сайта
+func (bot *TimetableBot) ScheduleModTime() time.Time {
+	bot.scheduleMu.RLock()
+	defer bot.scheduleMu.RUnlock()
+	return bot.schedule.FetchedAt
+}
+
+// setSchedule атомарно подменяет расписание целиком
+func (bot *TimetableBot) setSchedule(schedule *parser.Schedule) {
+	bot.scheduleMu.Lock()
+	defer bot.scheduleMu.Unlock()
+	bot.schedule = schedule
+}
+
+func (bot *TimetableBot) LoadSchedule(filename string) error {
+	slog.Info("📂 Загружаю расписание", "file", filename)
+
+	schedule, err := ReadSchedule(filename)
+	if os.IsNotExist(err) {
+		slog.Error("❌ Файл расписания не найден", "file", filename)
+		slog.Info("💡 Запусти сначала парсер: ./msuparser fetch")
+		return err
+	}
+	if err != nil {
+		slog.Error("❌ Ошибка загрузки расписания", "file", filename, "err", err)
+		return err
+	}
+
+	bot.setSchedule(schedule)
+	bot.updateMu.Lock()
+	bot.scheduleWatcher = newFileWatcher(filename)
+	bot.updateMu.Unlock()
+
+	slog.Info("✅ Расписание загружено", "file", filename, "lessons", len(schedule.Lessons))
+	return nil
+}
+
+// UpdateSchedule загружает расписание с сайта прямо в процессе бота.
+// Новое расписание заменяет текущее и записывается в schedule.json только
+// если прошло проверку, иначе бот продолжает работать со старым.
+// Результат, в том числе ошибку, пишет в журнал сама
+func (bot *TimetableBot) UpdateSchedule() error {
+	bot.updateMu.Lock()
+	defer bot.updateMu.Unlock()
+
+	started := time.Now()
+	previous := bot.Schedule()
+	schedule, err := bot.fetchSchedule()
+	bot.recordFetch(err)
+	bot.saveFetchStatus(err)
+	bot.errors.Record(SubsystemFetch, err)
+	if err != nil {
+		slog.Error("❌ Не удалось обновить расписание", "step", fetchErrorKind(err),
+			"duration", time.Since(started).Round(time.Millisecond), "err", err)
+		return err
+	}
+
+	diff := DiffSchedules(previous, schedule.Lessons)
+	bot.setSchedule(schedule)
+	slog.Info("✅ Расписание получено с сайта", "lessons", len(schedule.Lessons),
+		"added", len(diff.Added), "removed", len(diff.Removed), "changed", len(diff.Changed),
+		"duration", time.Since(started).Round(time.Millisecond))
+	bot.reviseReminders(diff, schedule.Lessons)
+
+	bot.goBackground(bot.syncCalDAV)
+
+	if added, err := bot.runtime().history.Add(schedule); err != nil {
+		slog.Warn("⚠️ Не удалось сохранить расписание в архив", "err", err)
+	} else if added {
+		slog.Info("🗂️ Расписание изменилось, снимок сохранен в архив")
+	}
+
+	if err := SaveSchedule(ScheduleFile, schedule); err != nil {
+		slog.Error("❌ Расписание обновлено, но не сохранено", "file", ScheduleFile, "err", err)
+		bot.errors.Record(SubsystemFetch, err)
+		return fmt.Errorf("расписание обновлено, но не сохранено: %w", err)
+	}
+	// Свою запись не считаем внешним изменением
+	if bot.scheduleWatcher != nil {
+		bot.scheduleWatcher.Reset()
+	} else {
+		bot.scheduleWatcher = newFileWatcher(ScheduleFile)
+	}
+	return nil
+}
+
+// fetchSchedule загружает и проверяет расписание, не трогая текущее
+func (bot *TimetableBot) fetchSchedule() (*parser.Schedule, error) {
+	scheduleParser, err := parser.NewScheduleParser(bot.runtime().parserConfig)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания парсера: %w", err)
+	}
+
+	schedule, err := scheduleParser.FetchSchedule()
+	if err != nil {
+		return nil, err
+	}
+	metricLessonsParsed.Set(float64(len(schedule.Lessons)))
+	if err := parser.Validate(schedule.Lessons); err != nil {
+		return nil, fmt.Errorf("оставляю старое расписание: %w", err)
+	}
+	return schedule, nil
+}
+
+// syncCalDAV выгружает изменения расписания в CalDAV, если он настроен
+func (bot *TimetableBot) syncCalDAV() {
+	caldav := bot.runtime().caldav
+	if caldav == nil {
+		return
+	}
+
+	started := time.Now()
+	result, err := caldav.Sync(bot.Schedule())
+	if err != nil {
+		slog.Warn("⚠️ Ошибка синхронизации CalDAV", "err", err, "created", result.Created, "updated", result.Updated,
+			"deleted", result.Deleted, "unchanged", result.Unchanged, "failed", result.Failed)
+		bot.errors.Record(SubsystemCalDAV, err)
+		return
+	}
+	slog.Info("📆 CalDAV синхронизирован", "created", result.Created, "updated", result.Updated,
+		"deleted", result.Deleted, "unchanged", result.Unchanged, "duration", time.Since(started).Round(time.Millisecond))
+}
+
+func (bot *TimetableBot) FormatNotification(lesson *parser.Lesson) string {
+	message := fmt.Sprintf(
+		"🔔 <b>Скоро пара!</b>\n\n"+
+			"📚 <b>Предмет:</b> %s\n"+
+			"👨‍🏫 <b>Преподаватель:</b> %s\n"+
+			"🚪 <b>Аудитория:</b> %s\n\n"+
+			"🕐 <b>Время:</b> %s - %s\n"+
+			"📅 <b>Дата:</b> %s (%s)",
+		html.EscapeString(lesson.Subject),
+		html.EscapeString(lesson.Teacher),
+		html.EscapeString(lesson.Room),
+		lesson.TimeStart,
+		lesson.TimeEnd,
+		lesson.Date,
+		lesson.Weekday,
+	)
+	return message
+}
+
+// ParseTime разбирает дату и время пары в часовом поясе расписания
+func ParseTime(dateStr, timeStr string, loc *time.Location) (time.Time, error) {
+	dateTimeStr := fmt.Sprintf("%s %s", dateStr, timeStr)
+	return time.ParseInLocation("02.01.2006 15:04", dateTimeStr, loc)
+}
+
+// now возвращает текущее время по часам бота в часовом поясе расписания
+func (bot *TimetableBot) now() time.Time {
+	return bot.clock.Now().In(bot.runtime().location)
+}
+
+// GetUpcomingLessons возвращает пары, уведомление о которых еще впереди,
+// с учетом времени напоминания из настроек чата
+func (bot *TimetableBot) GetUpcomingLessons(settings ChatSettings) []parser.Lesson {
+	rt := bot.runtime()
+	now := bot.now()
+	upcoming := []parser.Lesson{}
+
+	for _, lesson := range bot.Schedule() {
+		if settings.Hides(&lesson, rt.config.Filters) {
+			continue
+		}
+
+		notificationTime, err := ParseTime(lesson.Date, lesson.TimeStart, rt.location)
+		if err != nil {
+			continue
+		}
+
+		// Устанавливаем время уведомления по настройкам чата
+		notificationTime = notificationTime.Add(-settings.LeadTime(&lesson))
+
+		// Проверяем что пара в будущем
+		if now.Before(notificationTime) {
+			lesson.Notification = notificationTime
+			upcoming = append(upcoming, lesson)
+		}
+	}
+
+	return upcoming
+}
+
+// isDistanceLearning проверяет является ли пара дистанционной
+func isDistanceLearning(room string) bool {
+	room = strings.ToLower(strings.TrimSpace(room))
+	return strings.Contains(room, "дистанц") || strings.Contains(room, "виртуал")
+}
+
+// SendDistanceLearningNotification отправляет уведомление о дистанционных парах за день
+func (bot *TimetableBot) SendDistanceLearningNotification(chatID int64, date string, lessons []parser.Lesson) {
+	key := fmt.Sprintf("%d_%s", chatID, date)
+	if bot.sentDistanceNotifications.Has(key) {
+		return
+	}
+
+	message := fmt.Sprintf(
+		"📱 <b>Утреннее напоминание</b>\n\n" +
+			"У вас сегодня дистанционные пары:\n\n",
+	)
+
+	for _, lesson := range lessons {
+		message += fmt.Sprintf(
+			"• %s пара (%s-%s)\n  📚 %s\n",
+			lesson.LessonNumber,
+			lesson.TimeStart,
+			lesson.TimeEnd,
+			html.EscapeString(lesson.Subject),
+		)
+		if lesson.Teacher != "" {
+			message += fmt.Sprintf("  👨‍🏫 %s\n", html.EscapeString(lesson.Teacher))
+		}
+	}
+
+	err := bot.SendMessageToChat(chatID, message)
+	if err == nil {
+		metricNotificationsQueued.Inc("distance")
+		slog.Info("📨 Уведомление о дистанционных парах в очереди", "chat_id", chatID, "date", date, "lessons", len(lessons))
+		bot.sentDistanceNotifications.Mark(key)
+	}
+}
+
+// GetTodayDistanceLessons возвращает все дистанционные пары на сегодня, не скрытые фильтрами чата
+func (bot *TimetableBot) GetTodayDistanceLessons(chat ChatSettings) map[string][]parser.Lesson {
+	today := bot.now().Format("02.01.2006")
+	global := bot.runtime().config.Filters
+
+	distanceLessons := make(map[string][]parser.Lesson)
+
+	for _, lesson := range bot.Schedule() {
+		if lesson.Date == today && isDistanceLearning(lesson.Room) && !chat.Hides(&lesson, global) {
+			distanceLessons[lesson.Date] = append(distanceLessons[lesson.Date], lesson)
+		}
+	}
+
+	return distanceLessons
+}
+
+// HasInPersonLessonsToday проверяет есть ли у чата очные пары сегодня
+func (bot *TimetableBot) HasInPersonLessonsToday(chat ChatSettings) bool {
+	return bot.HasInPersonLessons(chat, bot.now().Format("02.01.2006"))
+}
+
+// HasInPersonLessons проверяет есть ли у чата очные пары в день date (ДД.ММ.ГГГГ)
+func (bot *TimetableBot) HasInPersonLessons(chat ChatSettings, date string) bool {
+	global := bot.runtime().config.Filters
+
+	for _, lesson := range bot.Schedule() {
+		if lesson.Date == date && !isDistanceLearning(lesson.Room) && !chat.Hides(&lesson, global) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bot *TimetableBot) CheckAndSendNotifications(now time.Time) {
+	for _, chat := range bot.subscribers.All() {
+		bot.checkChatNotifications(chat, now)
+	}
+}
+
+// checkChatNotifications отправляет уведомления одному чату по его настройкам
+func (bot *TimetableBot) checkChatNotifications(chat ChatSettings, now time.Time) {
+	hasInPerson := bot.HasInPersonLessonsToday(chat)
+
+	// Проверяем: если ВСЕ пары дистанционные (нет очных) - отправляем утреннее уведомление в 8:00
+	if now.Hour() == 8 && now.Minute() == 0 {
+		// Отправляем утреннее уведомление только если нет очных пар
+		if chat.MorningDigest && !hasInPerson && !chat.IsQuiet(now) {
+			distanceLessons := bot.GetTodayDistanceLessons(chat)
+			for date, lessons := range distanceLessons {
+				bot.SendDistanceLearningNotification(chat.ChatID, date, lessons)
+			}
+		}
+	}
+
+	for _, lesson := range bot.GetUpcomingLessons(chat) {
+		// Если есть очные пары сегодня - отправляем уведомления для ВСЕХ пар (включая дистанционные)
+		// Если НЕТ очных пар - дистанционные только по желанию чата (обычно хватает утреннего уведомления)
+		if !hasInPerson && isDistanceLearning(lesson.Room) && !chat.DistanceReminders {
+			continue
+		}
+
+		if chat.IsQuiet(lesson.Notification.In(now.Location())) {
+			continue
+		}
+
+		lessonKey := fmt.Sprintf("%d_%s_%s_%s", chat.ChatID, lesson.Date, lesson.LessonNumber, lesson.Subject)
+
+		if bot.sentNotifications.Has(lessonKey) {
+			continue
+		}
+
+		timeDiff := lesson.Notification.Sub(now).Seconds()
+
+		// Отправляем если осталось меньше 60 секунд
+		if timeDiff >= 0 && timeDiff <= 60 {
+			message := bot.FormatNotification(&lesson)
+			err := bot.outbox.Push(OutgoingMessage{ChatID: chat.ChatID, Text: message, Ref: lesson.ID()})
+			if err == nil {
+				metricNotificationsQueued.Inc("lesson")
+				slog.Info("📨 Уведомление в очереди", "chat_id", chat.ChatID, "lesson_id", lesson.ID(),
+					"subject", lesson.Subject, "date", lesson.Date, "time", lesson.TimeStart)
+				bot.sentNotifications.Mark(lessonKey)
+			}
+		}
+	}
+}
+
+// RunScheduler запускает отправку сообщений, прием команд и HTTP сервер и
+// раз в минуту проверяет расписание, пока не отменен ctx. Затем останавливает бота.
+// Возвращает ошибку, если бот остановился не по сигналу, а из-за сбоя
+func (bot *TimetableBot) RunScheduler(ctx context.Context) error {
+	ctx, fail := context.WithCancelCause(ctx)
+	defer fail(nil)
+
+	slog.Info("🤖 Бот запущен", "updates_mode", bot.updatesMode, "check_interval", CheckInterval,
+		"lead_minutes", bot.runtime().config.NotificationMinutes, "subscribers", len(bot.subscribers.All()))
+
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+
+	bot.beat()
+	bot.goBackground(func() { bot.outbox.Run(ctx) })
+	bot.startUpdates(ctx, fail)
+	if bot.httpListen != "" {
+		bot.StartHTTPServer(ctx)
+	}
+	bot.StartMetricsServer(ctx)
+
+	// Watchdog пингуется из того же цикла, что и планировщик: если он
+	// зависнет, systemd перезапустит бота
+	var watchdog <-chan time.Time
+	if interval := sdWatchdogInterval(); interval > 0 {
+		watchdogTicker := time.NewTicker(interval)
+		defer watchdogTicker.Stop()
+		watchdog = watchdogTicker.C
+	}
+	sdNotify(fmt.Sprintf("READY=1\nSTATUS=Пар в расписании: %d", len(bot.Schedule())))
+
+	for {
+		select {
+		case <-ticker.C:
+			bot.tick(bot.now())
+			bot.beat()
+		case <-watchdog:
+			sdNotify("WATCHDOG=1")
+		case <-ctx.Done():
+			bot.shutdown()
+			if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+				return err
+			}
+			return nil
+		}
+	}
+}
+
+// tick выполняет ежеминутную работу планировщика на момент now
+func (bot *TimetableBot) tick(now time.Time) {
+	// Обновляем расписание каждый день в 2:00, если сегодня еще не обновляли
+	if now.Hour() == 2 && now.Minute() == 0 && now.Sub(bot.lastUpdateRun) > 23*time.Hour {
+		slog.Info("🔄 Ежедневное обновление расписания")
+		bot.UpdateSchedule()
+		bot.lastUpdateRun = now
+	}
+
+	bot.reloadChangedFiles()
+	bot.checkStaleSchedule(now)
+	bot.CheckAndSendNotifications(now)
+}
+
+// Run загружает расписание и подписчиков и работает до отмены ctx.
+// Ошибка означает, что бот не запустился или упал, и процесс должен
+// завершиться с ненулевым кодом
+func (bot *TimetableBot) Run(ctx context.Context) error {
+	bot.loadFetchStatus()
+	if err := bot.LoadSchedule(ScheduleFile); err != nil {
+		// Без сохраненного расписания пробуем сразу получить его с сайта
+		slog.Info("🔄 Загружаю расписание с сайта")
+		if err := bot.UpdateSchedule(); err != nil {
+			return err
+		}
+	}
+
+	// Владельца подписываем только при первом запуске: если он потом
+	// отписался через /stop, перезапуск не должен подписывать его снова
+	_, err := os.Stat(SubscribersFile)
+	firstRun := errors.Is(err, os.ErrNotExist)
+	if err := bot.subscribers.Load(); err != nil {
+		slog.Error("❌ Ошибка загрузки подписчиков", "file", SubscribersFile, "err", err)
+		return err
+	}
+
+	if err := bot.reminders.Load(); err != nil {
+		slog.Warn("⚠️ Ошибка загрузки отправленных напоминаний", "file", RemindersFile, "err", err)
+	}
+
+	if err := bot.access.Load(); err != nil {
+		slog.Error("❌ Ошибка загрузки блокировок", "file", AccessFile, "err", err)
+		return err
+	}
+	if on, _ := bot.access.Maintenance(); on {
+		slog.Warn("🛠 Включен режим обслуживания, команды пользователей не выполняются")
+	}
+
+	if chatID, err := strconv.ParseInt(bot.userID, 10, 64); err != nil {
+		slog.Warn("⚠️ USER_ID не является числовым ID чата", "user_id", bot.userID)
+	} else if firstRun {
+		if _, err := bot.subscribers.Subscribe(chatID); err != nil {
+			slog.Warn("⚠️ Не удалось сохранить подписчиков", "err", err)
+		} else {
+			slog.Info("👤 Владелец из конфига подписан на уведомления", "chat_id", chatID)
+		}
+	}
+
+	// Сообщения, которые не успели отправить перед прошлой остановкой
+	if n, err := bot.outbox.Load(OutboxFile); err != nil {
+		slog.Warn("⚠️ Ошибка загрузки сохраненной очереди", "file", OutboxFile, "err", err)
+	} else if n > 0 {
+		slog.Info("📤 В очередь возвращены сообщения с прошлого запуска", "messages", n)
+	}
+
+	bot.identify(ctx)
+	bot.goBackground(bot.syncCalDAV)
+
+	// Запускаем планировщик
+	return bot.RunScheduler(ctx)
+}
+
+// identify узнает имя бота. Без него в группе нельзя отличить команду
+// этому боту от /today@другой_бот, и тогда выполняются все такие команды
+func (bot *TimetableBot) identify(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	me, err := bot.telegram.GetMe(ctx)
+	if err != nil {
+		slog.Warn("⚠️ Не удалось узнать имя бота", "err", err)
+		return
+	}
+	bot.username = me.Username
+	slog.Info("🤖 Бот авторизован", "username", me.Username)
+}
+
+// startUpdates запускает прием команд: webhook сервер или long polling.
+// Без webhook бот не получает команды, поэтому его ошибка передается в
+// fail и останавливает бота целиком
+func (bot *TimetableBot) startUpdates(ctx context.Context, fail context.CancelCauseFunc) {
+	if bot.updatesMode == UpdatesModeWebhook {
+		bot.goBackground(func() {
+			if err := bot.ServeWebhook(ctx); err != nil {
+				slog.Error("❌ Webhook сервер остановлен", "err", err)
+				fail(fmt.Errorf("webhook: %w", err))
+			}
+		})
+		return
+	}
+
+	// Если раньше был включен webhook, getUpdates будет отвечать 409
+	if err := bot.telegram.DeleteWebhook(ctx); err != nil {
+		slog.Warn("⚠️ Не удалось удалить webhook", "err", err)
+	}
+
+	// Запускаем опрос обновлений в отдельной горутине. Смещение живет
+	// только в ней, поэтому не требует блокировок
+	bot.goBackground(func() {
+		lastUpdateID := 0
+		for ctx.Err() == nil {
+			lastUpdateID = bot.PollUpdates(ctx, lastUpdateID)
+			select {
+			case <-ctx.Done():
+			case <-time.After(1 * time.Second):
+			}
+		}
+		bot.confirmUpdates(lastUpdateID)
+	})
+}
+
+// PollUpdates получает и обрабатывает обновления после lastUpdateID и
+// возвращает ID последнего обработанного. Отмена ctx прерывает ожидание,
+// но уже полученные обновления обрабатываются до конца
+func (bot *TimetableBot) PollUpdates(ctx context.Context, lastUpdateID int) int {
+	updates, err := bot.telegram.GetUpdates(ctx, GetUpdatesRequest{
+		Offset:  lastUpdateID + 1,
+		Timeout: 30,
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("⚠️ Ошибка получения обновлений", "err", err)
+		}
+		return lastUpdateID
+	}
+
+	for _, update := range updates {
+		lastUpdateID = update.UpdateID
+		bot.HandleUpdate(update)
+	}
+	return lastUpdateID
+}
+
+// confirmUpdates подтверждает Telegram обработанные обновления. Иначе
+// последняя пачка придет снова после перезапуска и команды выполнятся дважды.
+// Обновления, полученные этим запросом, не подтверждаются и не теряются
+func (bot *TimetableBot) confirmUpdates(lastUpdateID int) {
+	if lastUpdateID == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := bot.telegram.GetUpdates(ctx, GetUpdatesRequest{Offset: lastUpdateID + 1, Limit: 1}); err != nil {
+		slog.Warn("⚠️ Не удалось подтвердить обновления", "update_id", lastUpdateID, "err", err)
+	}
+}
+
+// HandleUpdate выполняет команду из обновления. Команды заблокированных
+// игнорируются, в режиме обслуживания пользователи получают только ответ о нем
+func (bot *TimetableBot) HandleUpdate(update Update) {
+	msg := update.Message
+	text := strings.TrimSpace(msg.Text)
+	if !strings.HasPrefix(text, "/") {
+		return
+	}
+
+	fields := strings.Fields(text)
+	// В группах команду можно адресовать конкретному боту: /today@имя_бота
+	command, mention, _ := strings.Cut(fields[0], "@")
+	if mention != "" && bot.username != "" && !strings.EqualFold(mention, bot.username) {
+		return
+	}
+	args := fields[1:]
+	from := msg.SenderID()
+	slog.Debug("Команда", "chat_id", msg.Chat.ID, "user_id", from, "update_id", update.UpdateID, "command", command)
+
+	if bot.access.IsBanned(from, msg.Chat.ID) {
+		slog.Debug("Команда заблокированного пользователя пропущена", "chat_id", msg.Chat.ID, "user_id", from)
+		return
+	}
+
+	if _, ok := adminCommands[command]; ok {
+		bot.handleAdminCommand(adminCommand{
+			From:   from,
+			ChatID: msg.Chat.ID,
+			Name:   command,
+			Args:   args,
+			Text:   strings.TrimSpace(strings.TrimPrefix(text, fields[0])),
+		})
+		return
+	}
+
+	// /stop работает всегда: отписаться можно и во время обслуживания
+	if on, message := bot.access.Maintenance(); on && command != "/stop" && !bot.isAdmin(from) {
+		bot.SendMessageToChat(msg.Chat.ID, "🛠 "+html.EscapeString(message))
+		return
+	}
+
+	bot.handleCommand(msg, command, args)
+}
+
+// SendMessageToChat ставит сообщение в очередь на отправку.
+// Повторы, лимиты Telegram и недоставленные сообщения обрабатывает Outbox
+func (bot *TimetableBot) SendMessageToChat(chatID int64, message string) error {
+	return bot.outbox.Enqueue(chatID, message)
+}
+
+// handleChatMigrated переносит настройки группы, ставшей супергруппой, на новый ID
+func (bot *TimetableBot) handleChatMigrated(from, to int64) {
+	if err := bot.subscribers.Migrate(from, to); err != nil {
+		slog.Warn("⚠️ Не удалось перенести настройки группы", "chat_id", from, "new_chat_id", to, "err", err)
+		return
+	}
+	slog.Info("🔀 Настройки группы перенесены", "chat_id", from, "new_chat_id", to)
+}
+
+// chatThread возвращает тему форума, выбранную в чате командой /topic
+func (bot *TimetableBot) chatThread(chatID int64) int {
+	settings, _ := bot.subscribers.Get(chatID)
+	return settings.ThreadID
+}
+
+// resetChatThread возвращает сообщения чата в общую тему, если выбранной больше нет
+func (bot *TimetableBot) resetChatThread(chatID int64) {
+	if _, err := bot.subscribers.Update(chatID, func(s *ChatSettings) { s.ThreadID = 0 }); err != nil {
+		slog.Warn("⚠️ Не удалось сбросить тему форума", "chat_id", chatID, "err", err)
+	}
+}
+
+// handleChatGone отписывает чат, в который бот больше не может писать
+func (bot *TimetableBot) handleChatGone(chatID int64) {
+	if _, ok := bot.subscribers.Get(chatID); !ok {
+		return
+	}
+	if err := bot.subscribers.Unsubscribe(chatID); err != nil {
+		slog.Warn("⚠️ Не удалось отписать чат", "chat_id", chatID, "err", err)
+		return
+	}
+	slog.Info("👋 Чат отписан: бот заблокирован или удален", "chat_id", chatID)
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"msuparser/parser"
+)
+
+// fixedClock часы, которые стоят на месте, пока тест их не переведет
+type fixedClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fixedClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// testChatID подписчик в тестах планировщика, владелец из конфига - "42"
+const testChatID = 1001
+
+// newTestBot создает бота поверх заглушки Bot API с файлами состояния во
+// временном каталоге и часами clock
+func newTestBot(t *testing.T, api *fakeBotAPI, clock Clock) *TimetableBot {
+	t.Chdir(t.TempDir())
+	bot := NewTimetableBot(api.client(), "42")
+	bot.clock = clock
+	bot.applyConfig(DefaultConfig())
+	return bot
+}
+
+// at разбирает "ДД.ММ.ГГГГ ЧЧ:ММ:СС" в часовом поясе расписания
+func at(t *testing.T, value string) time.Time {
+	t.Helper()
+	loc, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moment, err := time.ParseInLocation("02.01.2006 15:04:05", value, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return moment
+}
+
+// tickAt переводит часы бота и выполняет один цикл планировщика
+func tickAt(bot *TimetableBot, clock *fixedClock, now time.Time) {
+	clock.Set(now)
+	bot.tick(now)
+}
+
+// queuedTexts возвращает тексты сообщений чата, стоящих в очереди
+func queuedTexts(bot *TimetableBot, chatID int64) []string {
+	var texts []string
+	for _, msg := range bot.outbox.Snapshot() {
+		if msg.ChatID == chatID {
+			texts = append(texts, msg.Text)
+		}
+	}
+	return texts
+}
+
+// tickSchedule вторник с очными парами (1 и 3) и среда только с дистанционной
+func tickSchedule() *parser.Schedule {
+	first := testLesson("20.10.2026", "09:00", "Право [Семинар]", "101")
+	third := testLesson("20.10.2026", "13:00", "Экономика [Лекция]", "202")
+	third.LessonNumber, third.TimeEnd = "3", "14:30"
+	distance := testLesson("21.10.2026", "09:00", "Философия [Лекция]", "Дистанционно")
+	return &parser.Schedule{Lessons: []parser.Lesson{first, third, distance}}
+}
+
+func TestTickNotifications(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings func(*ChatSettings) // nil - настройки по умолчанию
+		times    []string            // Циклы планировщика по порядку
+		want     []string            // Подстроки сообщений в очереди, по одной на сообщение
+	}{
+		{
+			name:  "напоминание за 15 минут",
+			times: []string{"20.10.2026 08:44:30"},
+			want:  []string{"Право"},
+		},
+		{
+			name:  "до окна напоминания",
+			times: []string{"20.10.2026 08:43:30"},
+		},
+		{
+			name:  "окно напоминания прошло",
+			times: []string{"20.10.2026 08:45:30"},
+		},
+		{
+			name:  "3 пара не меньше чем за 45 минут",
+			times: []string{"20.10.2026 12:14:30"},
+			want:  []string{"Экономика"},
+		},
+		{
+			name:     "время из /lead отменяет минимум для 3 пары",
+			settings: func(s *ChatSettings) { s.LeadCustom = true },
+			times:    []string{"20.10.2026 12:14:30", "20.10.2026 12:44:30"},
+			want:     []string{"Экономика"},
+		},
+		{
+			name:  "утренняя сводка в день только с дистанционными",
+			times: []string{"21.10.2026 08:00:00"},
+			want:  []string{"Утреннее напоминание"},
+		},
+		{
+			name:  "сводка только в 8:00",
+			times: []string{"21.10.2026 07:59:00", "21.10.2026 08:01:00"},
+		},
+		{
+			name:  "без сводки в день с очными парами",
+			times: []string{"20.10.2026 08:00:00"},
+		},
+		{
+			name:     "сводка отключена",
+			settings: func(s *ChatSettings) { s.MorningDigest = false },
+			times:    []string{"21.10.2026 08:00:00"},
+		},
+		{
+			name:     "напоминание в тихие часы",
+			settings: func(s *ChatSettings) { s.QuietStart, s.QuietEnd = "08:00", "09:00" },
+			times:    []string{"20.10.2026 08:44:30"},
+		},
+		{
+			name:     "сводка в тихие часы",
+			settings: func(s *ChatSettings) { s.QuietStart, s.QuietEnd = "07:00", "09:00" },
+			times:    []string{"21.10.2026 08:00:00"},
+		},
+		{
+			name:     "тихие часы через полночь закончились",
+			settings: func(s *ChatSettings) { s.QuietStart, s.QuietEnd = "23:00", "08:00" },
+			times:    []string{"20.10.2026 08:44:30"},
+			want:     []string{"Право"},
+		},
+		{
+			name:  "повторный цикл в ту же минуту не дублирует",
+			times: []string{"20.10.2026 08:44:10", "20.10.2026 08:44:50", "21.10.2026 08:00:00", "21.10.2026 08:00:30"},
+			want:  []string{"Право", "Утреннее напоминание"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fixedClock{}
+			bot := newTestBot(t, newFakeBotAPI(t), clock)
+			bot.setSchedule(tickSchedule())
+			if _, err := bot.subscribers.Subscribe(testChatID); err != nil {
+				t.Fatal(err)
+			}
+			if tt.settings != nil {
+				if _, err := bot.subscribers.Update(testChatID, tt.settings); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, moment := range tt.times {
+				tickAt(bot, clock, at(t, moment))
+			}
+
+			texts := queuedTexts(bot, testChatID)
+			if len(texts) != len(tt.want) {
+				t.Fatalf("в очереди %d сообщений, ожидалось %d: %q", len(texts), len(tt.want), texts)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(texts[i], want) {
+					t.Errorf("сообщение %d: %q, ожидалось с %q", i+1, texts[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestNotificationsEscapeLessonText(t *testing.T) {
+	clock := &fixedClock{}
+	bot := newTestBot(t, newFakeBotAPI(t), clock)
+	inPerson := testLesson("20.10.2026", "09:00", "R&D <br> практикум [Семинар]", "<101>")
+	distance := testLesson("21.10.2026", "09:00", "Право & экономика [Лекция]", "Дистанционно")
+	distance.Teacher = "<Иванов>"
+	bot.setSchedule(&parser.Schedule{Lessons: []parser.Lesson{inPerson, distance}})
+	if _, err := bot.subscribers.Subscribe(testChatID); err != nil {
+		t.Fatal(err)
+	}
+
+	tickAt(bot, clock, at(t, "20.10.2026 08:44:30"))
+	tickAt(bot, clock, at(t, "21.10.2026 08:00:00"))
+
+	texts := queuedTexts(bot, testChatID)
+	if len(texts) != 2 {
+		t.Fatalf("в очереди %d сообщений, ожидалось 2: %q", len(texts), texts)
+	}
+	for i, want := range [][]string{
+		{"R&amp;D &lt;br&gt; практикум", "&lt;101&gt;"},
+		{"Право &amp; экономика", "&lt;Иванов&gt;"},
+	} {
+		for _, part := range want {
+			if !strings.Contains(texts[i], part) {
+				t.Errorf("сообщение %d: %q, ожидалось с %q", i+1, texts[i], part)
+			}
+		}
+	}
+}
+
+// newFakeTimetableSite заглушка tt.audit.msu.ru: форма с CSRF токеном и
+// таблица с одной парой на дату из запроса. Возвращает сервер и счетчик
+// запросов расписания
+func newFakeTimetableSite(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var fetches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /time-table/group", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<form><input type="hidden" name="_csrf-frontend" value="csrf-token"></form>`)
+	})
+	mux.HandleFunc("POST /time-table/group", func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.PostFormValue("_csrf-frontend") != "csrf-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		date := r.PostFormValue("TimeTableForm[dateStart]")
+		content := html.EscapeString("Право [Семинар]<br>ауд. 101<br>Иванов И.И.")
+		fmt.Fprintf(w, `<table id="timeTable">
+<tr><th class="headday">%[1]s</th></tr>
+<tr><th class="headcol"><span class="start">09:00</span><span class="end">10:30</span></th>
+<td><div data-toggle="popover" title="%[1]s 1 пара" data-content="%[2]s"></div></td></tr>
+</table>`, date, content)
+	})
+	site := httptest.NewServer(mux)
+	t.Cleanup(site.Close)
+	return site, &fetches
+}
+
+func TestTickDailyUpdate(t *testing.T) {
+	site, fetches := newFakeTimetableSite(t)
+	clock := &fixedClock{}
+	bot := newTestBot(t, newFakeBotAPI(t), clock)
+	bot.runtimeCfg.parserConfig.BaseURL = site.URL
+
+	steps := []struct {
+		time    string
+		fetches int32
+	}{
+		{"20.10.2026 01:59:00", 0},
+		{"20.10.2026 02:00:00", 1},
+		{"20.10.2026 02:00:30", 1}, // Тот же день: второй раз не загружаем
+		{"20.10.2026 02:01:00", 1},
+		{"21.10.2026 02:00:00", 2},
+	}
+	for _, step := range steps {
+		tickAt(bot, clock, at(t, step.time))
+		if got := fetches.Load(); got != step.fetches {
+			t.Fatalf("%s: загрузок расписания %d, ожидалось %d", step.time, got, step.fetches)
+		}
+	}
+	bot.background.Wait()
+
+	lessons := bot.Schedule()
+	if len(lessons) != 1 || lessons[0].Date != "21.10.2026" || lessons[0].Group != "303" {
+		t.Fatalf("расписание после обновления: %+v", lessons)
+	}
+	saved, err := ReadSchedule(ScheduleFile)
+	if err != nil {
+		t.Fatalf("%s: %v", ScheduleFile, err)
+	}
+	if len(saved.Lessons) != 1 {
+		t.Errorf("в %s %d пар, ожидалась 1", ScheduleFile, len(saved.Lessons))
+	}
+	if record, err := readFetchRecord(FetchStatusFile); err != nil || record.Error != "" {
+		t.Errorf("%s: %+v, %v", FetchStatusFile, record, err)
+	}
+}
+
+// TestBotConcurrentWork гоняет планировщик, команды, перечитывание файлов и
+// загрузку расписания одновременно, как в работающем боте. Смысл теста - в
+// запуске с детектором гонок: make race
+func TestBotConcurrentWork(t *testing.T) {
+	site, _ := newFakeTimetableSite(t)
+	api := newFakeBotAPI(t)
+	bot := newTestBot(t, api, systemClock{})
+
+	config := DefaultConfig()
+	config.BotToken = testToken
+	config.UserID = "42"
+	config.TelegramAPIURL = api.server.URL
+	config.TimetableURL = site.URL
+	writeConfig := func(config Config) {
+		data, err := json.Marshal(config)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := os.WriteFile(ConfigFile, data, 0644); err != nil {
+			t.Error(err)
+		}
+	}
+	writeConfig(config)
+	bot.configSource = &ConfigSource{Path: ConfigFile}
+	bot.configWatcher = newFileWatcher(ConfigFile)
+	bot.applyConfig(config)
+
+	// Пара, напоминание о которой приходится на ближайшую минуту
+	start := bot.now().Add(16 * time.Minute)
+	soon := testLesson(start.Format("02.01.2006"), start.Format("15:04"), "Право [Семинар]", "101")
+	soon.TimeEnd = start.Add(90 * time.Minute).Format("15:04")
+	if err := SaveSchedule(ScheduleFile, &parser.Schedule{Lessons: []parser.Lesson{soon}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.LoadSchedule(ScheduleFile); err != nil {
+		t.Fatal(err)
+	}
+	for _, chatID := range []int64{42, 1001, 1002} {
+		if _, err := bot.subscribers.Subscribe(chatID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var outboxDone sync.WaitGroup
+	outboxDone.Add(1)
+	go func() {
+		defer outboxDone.Done()
+		bot.outbox.Run(ctx)
+	}()
+
+	const rounds = 20
+	commands := []string{"/start", "/lead 20", "/quiet 23:00-08:00", "/mute subject Право", "/settings",
+		"/today", "/filters", "/unmute 1", "/digest off", "/status", "/queue", "/stop"}
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				f(i)
+			}
+		}()
+	}
+
+	run(func(int) { bot.tick(bot.now()) })
+	run(func(i int) {
+		chatID := int64(1002)
+		if i%2 == 0 {
+			chatID = 42 // Владелец: команды админа
+		}
+		bot.HandleUpdate(Update{UpdateID: i, Message: Message{
+			MessageID: i,
+			From:      &User{ID: chatID},
+			Chat:      Chat{ID: chatID, Type: "private"},
+			Text:      commands[i%len(commands)],
+		}})
+	})
+	run(func(i int) {
+		// Правки файлов снаружи, как при ручном редактировании или fetch
+		moved := soon
+		moved.Room = strconv.Itoa(200 + i)
+		if err := SaveSchedule(ScheduleFile, &parser.Schedule{Lessons: []parser.Lesson{moved}}); err != nil {
+			t.Error(err)
+		}
+		changed := config
+		changed.NotificationMinutes = 10 + i
+		writeConfig(changed)
+		bot.reloadChangedFiles()
+	})
+	run(func(int) { bot.UpdateSchedule() })
+
+	wg.Wait()
+	cancel()
+	outboxDone.Wait()
+	bot.background.Wait()
+
+	if len(bot.Schedule()) == 0 {
+		t.Error("после работы расписание пустое")
+	}
+	if len(api.callsTo("sendMessage")) == 0 {
+		t.Error("очередь не отправила ни одного сообщения")
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"msuparser/parser"
+)
+
+const CalDAVStateFile = "caldav_state.json"
+
+// caldavEntry запись о выгруженной паре: хэш содержимого и дата
+type caldavEntry struct {
+	Hash string `json:"hash"`
+	Date string `json:"date"`
+}
+
+// CalDAVResult итог синхронизации
+type CalDAVResult struct {
+	Created   int
+	Updated   int
+	Deleted   int
+	Unchanged int
+	Failed    int
+}
+
+// CalDAVSync выгружает расписание в CalDAV коллекцию (Radicale, Nextcloud).
+// Каждая пара - отдельный ресурс <UID>.ics. Что уже выгружено, хранится
+// в файле состояния, поэтому на сервер уходят только изменения
+type CalDAVSync struct {
+	collectionURL string
+	username      string
+	password      string
+	stateFile     string
+	location      *time.Location
+	client        *http.Client
+
+	mu sync.Mutex // Не даем двум синхронизациям идти одновременно
+}
+
+// NewCalDAVSync создает синхронизацию с коллекцией collectionURL; loc - часовой пояс расписания
+func NewCalDAVSync(collectionURL, username, password, stateFile string, loc *time.Location) *CalDAVSync {
+	if !strings.HasSuffix(collectionURL, "/") {
+		collectionURL += "/"
+	}
+	return &CalDAVSync{
+		collectionURL: collectionURL,
+		username:      username,
+		password:      password,
+		stateFile:     stateFile,
+		location:      loc,
+		client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Sync приводит коллекцию к расписанию lessons: новые и измененные пары
+// загружаются (PUT), исчезнувшие - удаляются (DELETE). Прошедшие пары,
+// которых уже нет в выгрузке с сайта, не трогаем
+func (c *CalDAVSync) Sync(lessons []parser.Lesson) (CalDAVResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var result CalDAVResult
+
+	state, err := c.loadState()
+	if err != nil {
+		return result, err
+	}
+
+	firstDate := firstLessonDate(lessons)
+	desired := make(map[string]bool, len(lessons))
+	for _, lesson := range lessons {
+		uid := lesson.ID()
+		desired[uid] = true
+
+		hash := lessonHash(&lesson)
+		old, exists := state[uid]
+		if exists && old.Hash == hash {
+			result.Unchanged++
+			continue
+		}
+
+		if err := c.put(uid, &lesson); err != nil {
+			slog.Warn("⚠️ CalDAV: не удалось загрузить пару", "uid", uid, "lesson_id", lesson.ID(), "date", lesson.Date, "subject", lesson.Subject, "err", err)
+			result.Failed++
+			continue
+		}
+
+		state[uid] = caldavEntry{Hash: hash, Date: lesson.Date}
+		if exists {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+	}
+
+	for uid, entry := range state {
+		if desired[uid] {
+			continue
+		}
+		// Сайт отдает расписание начиная с сегодняшнего дня, поэтому
+		// отсутствие прошедшей пары не значит, что ее отменили
+		if firstDate == "" || dateKey(entry.Date) < dateKey(firstDate) {
+			continue
+		}
+
+		if err := c.delete(uid); err != nil {
+			slog.Warn("⚠️ CalDAV: не удалось удалить пару", "uid", uid, "err", err)
+			result.Failed++
+			continue
+		}
+		delete(state, uid)
+		result.Deleted++
+	}
+
+	if err := c.saveState(state); err != nil {
+		return result, err
+	}
+	if result.Failed > 0 {
+		return result, fmt.Errorf("CalDAV: %d операций завершились ошибкой", result.Failed)
+	}
+	return result, nil
+}
+
+// put создает или заменяет ресурс пары
+func (c *CalDAVSync) put(uid string, lesson *parser.Lesson) error {
+	var body bytes.Buffer
+	if err := WriteICSResource(&body, lesson, ICSOptions{Stamp: time.Now(), Location: c.location}); err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(http.MethodPut, uid, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/calendar; charset=utf-8")
+
+	return c.do(req, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+}
+
+// delete удаляет ресурс пары. Уже удаленный ресурс - не ошибка
+func (c *CalDAVSync) delete(uid string) error {
+	req, err := c.newRequest(http.MethodDelete, uid, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+}
+
+func (c *CalDAVSync) newRequest(method, uid string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.collectionURL+uid+".ics", body)
+	if err != nil {
+		return nil, err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return req, nil
+}
+
+func (c *CalDAVSync) do(req *http.Request, okStatuses ...int) error {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	for _, status := range okStatuses {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s: статус %d", req.Method, req.URL.Path, resp.StatusCode)
+}
+
+func (c *CalDAVSync) loadState() (map[string]caldavEntry, error) {
+	state := make(map[string]caldavEntry)
+
+	data, err := ioutil.ReadFile(c.stateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга %s: %w", c.stateFile, err)
+	}
+	return state, nil
+}
+
+func (c *CalDAVSync) saveState(state map[string]caldavEntry) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.stateFile, data, 0644)
+}
+
+// lessonHash хэш всего, что попадает в событие календаря
+func lessonHash(lesson *parser.Lesson) string {
+	data, _ := json.Marshal(lesson)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// firstLessonDate возвращает дату самой ранней пары или "", если пар нет
+func firstLessonDate(lessons []parser.Lesson) string {
+	first := ""
+	for _, lesson := range lessons {
+		if first == "" || dateKey(lesson.Date) < dateKey(first) {
+			first = lesson.Date
+		}
+	}
+	return first
+}
+
+// dateKey превращает "15.12.2025" в "20251215" для сравнения дат строками
+func dateKey(date string) string {
+	t, err := time.Parse("02.01.2006", date)
+	if err != nil {
+		return ""
+	}
+	return t.Format("20060102")
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"msuparser/parser"
+)
+
+// fakeCollection CalDAV коллекция в памяти, отвечает как Radicale:
+// PUT - 201 для нового ресурса и 204 для замены, DELETE - 204 или 404
+type fakeCollection struct {
+	mu        sync.Mutex
+	resources map[string]string
+	requests  []string
+}
+
+func newFakeCollection(t *testing.T) (*fakeCollection, *httptest.Server) {
+	c := &fakeCollection{resources: make(map[string]string)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "student" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		name := strings.TrimPrefix(r.URL.Path, "/student/timetable/")
+		c.requests = append(c.requests, r.Method+" "+name)
+
+		switch r.Method {
+		case http.MethodPut:
+			if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+				t.Errorf("PUT %s: Content-Type %q", name, ct)
+			}
+			body, _ := io.ReadAll(r.Body)
+			_, exists := c.resources[name]
+			c.resources[name] = string(body)
+			if exists {
+				w.WriteHeader(http.StatusNoContent)
+			} else {
+				w.WriteHeader(http.StatusCreated)
+			}
+		case http.MethodDelete:
+			if _, exists := c.resources[name]; !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			delete(c.resources, name)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return c, server
+}
+
+// takeRequests возвращает запросы с прошлого вызова
+func (c *fakeCollection) takeRequests() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	requests := c.requests
+	c.requests = nil
+	return requests
+}
+
+// resource возвращает содержимое ресурса пары uid
+func (c *fakeCollection) resource(uid string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	body, ok := c.resources[uid+".ics"]
+	return body, ok
+}
+
+func testLesson(date, start, subject, room string) parser.Lesson {
+	return parser.Lesson{
+		Subject:      subject,
+		Teacher:      "Иванов И.И.",
+		Room:         room,
+		LessonNumber: "1",
+		TimeStart:    start,
+		TimeEnd:      "10:30",
+		Date:         date,
+		Weekday:      "Пн",
+		Group:        "303",
+	}
+}
+
+func TestCalDAVSync(t *testing.T) {
+	collection, server := newFakeCollection(t)
+	loc, _ := time.LoadLocation(DefaultTimezone)
+	stateFile := filepath.Join(t.TempDir(), CalDAVStateFile)
+	caldav := NewCalDAVSync(server.URL+"/student/timetable", "student", "secret", stateFile, loc)
+
+	past := testLesson("19.10.2026", "09:00", "История [Лекция]", "101")
+	changed := testLesson("20.10.2026", "09:00", "Право [Семинар]", "202")
+	removed := testLesson("21.10.2026", "09:00", "Экономика [Лекция]", "303")
+
+	// Первая выгрузка: все пары создаются
+	result, err := caldav.Sync([]parser.Lesson{past, changed, removed})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result != (CalDAVResult{Created: 3}) {
+		t.Fatalf("первая синхронизация: %+v", result)
+	}
+	collection.takeRequests()
+
+	// На следующий день сайт отдает расписание с 20.10: прошедшая пара
+	// пропала, одна пара поменяла аудиторию, одну отменили, одну добавили
+	moved := changed
+	moved.Room = "404"
+	added := testLesson("22.10.2026", "09:00", "Философия [Семинар]", "505")
+
+	result, err = caldav.Sync([]parser.Lesson{moved, added})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result != (CalDAVResult{Created: 1, Updated: 1, Deleted: 1}) {
+		t.Fatalf("вторая синхронизация: %+v", result)
+	}
+	if _, ok := collection.resource(past.ID()); !ok {
+		t.Error("прошедшая пара удалена из коллекции")
+	}
+	if _, ok := collection.resource(removed.ID()); ok {
+		t.Error("отмененная пара осталась в коллекции")
+	}
+	if body, _ := collection.resource(moved.ID()); !strings.Contains(body, "404") {
+		t.Error("измененная пара не перезаписана")
+	}
+	for _, request := range collection.takeRequests() {
+		if strings.HasSuffix(request, past.ID()+".ics") {
+			t.Errorf("лишний запрос к прошедшей паре: %s", request)
+		}
+	}
+
+	// Состояние сохраняется в файл: новый экземпляр ничего не отправляет заново
+	result, err = NewCalDAVSync(server.URL+"/student/timetable/", "student", "secret", stateFile, loc).
+		Sync([]parser.Lesson{moved, added})
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if result != (CalDAVResult{Unchanged: 2}) {
+		t.Fatalf("повторная синхронизация: %+v", result)
+	}
+	if requests := collection.takeRequests(); len(requests) != 0 {
+		t.Errorf("без изменений ушли запросы: %v", requests)
+	}
+}
+
+func TestCalDAVSyncReportsFailures(t *testing.T) {
+	_, server := newFakeCollection(t)
+	stateFile := filepath.Join(t.TempDir(), CalDAVStateFile)
+	caldav := NewCalDAVSync(server.URL+"/student/timetable/", "student", "wrong", stateFile, time.UTC)
+
+	result, err := caldav.Sync([]parser.Lesson{testLesson("20.10.2026", "09:00", "Право [Семинар]", "202")})
+	if err == nil {
+		t.Fatal("ошибка авторизации не вернулась из Sync")
+	}
+	if result.Failed != 1 {
+		t.Errorf("Failed = %d, ожидалось 1", result.Failed)
+	}
+
+	// Неудачная пара не попадает в состояние и уйдет при следующей синхронизации
+	state, err := caldav.loadState()
+	if err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if len(state) != 0 {
+		t.Errorf("в состоянии неотправленные пары: %v", state)
+	}
+}
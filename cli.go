@@ -0,0 +1,592 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"msuparser/parser"
+)
+
+// runFetch загружает расписание с сайта и сохраняет его (бывший test_parser)
+func runFetch(args []string) {
+	flags := flag.NewFlagSet("fetch", flag.ExitOnError)
+	output := flags.String("o", "schedule.json", "куда сохранить расписание, - для stdout")
+	format := flags.String("format", "", "формат: "+strings.Join(OutputFormats, ", ")+" (по умолчанию по расширению -o)")
+	from := flags.String("from", "", "только пары начиная с даты ДД.ММ.ГГГГ")
+	to := flags.String("to", "", "только пары до даты ДД.ММ.ГГГГ включительно")
+	quiet := flags.Bool("q", false, "ничего не печатать, кроме ошибок")
+	source := addConfigFlags(flags)
+	defaults := DefaultConfig()
+	flags.Int("faculty", defaults.FacultyID, "ID факультета (FACULTY_ID)")
+	flags.Int("course", defaults.Course, "курс (COURSE)")
+	flags.Int("group", defaults.GroupID, "ID группы (GROUP_ID)")
+	flags.String("group-name", defaults.GroupName, "название группы в парах и календарях (GROUP_NAME)")
+	flags.String("history", defaults.HistoryDir, "каталог архива выгрузок (HISTORY_DIR), пусто - не архивировать")
+	flags.Parse(args)
+
+	log.SetFlags(0)
+
+	// Явно заданные флаги перекрывают конфиг, остальное берется из него
+	flags.Visit(func(f *flag.Flag) {
+		if key, ok := fetchConfigFlags[f.Name]; ok {
+			source.Overrides = append(source.Overrides, key+"="+f.Value.String())
+		}
+	})
+	config := loadCommandConfig(source)
+
+	if *format == "" {
+		*format = FormatFromPath(*output)
+	}
+	// schedule.json читает бот: другой формат или обрезанный по датам кусок
+	// расписания сломал бы его, поэтому такую выгрузку нужно направить в другой файл
+	if *output == ScheduleFile {
+		if *format != FormatJSON {
+			log.Fatalf("❌ Формат %s нельзя сохранять в %s, укажите другой файл через -o (или -o - для stdout)", *format, ScheduleFile)
+		}
+		if *from != "" || *to != "" {
+			log.Fatalf("❌ Расписание с -from/-to нельзя сохранять в %s, укажите другой файл через -o (или -o - для stdout)", ScheduleFile)
+		}
+	}
+
+	// Если расписание идет в stdout, сообщения печатаем в stderr, чтобы не сломать пайплайн
+	var console io.Writer = os.Stdout
+	if *output == "-" {
+		console = os.Stderr
+	}
+	if *quiet {
+		console = ioutil.Discard
+	}
+
+	scheduleParser, err := parser.NewScheduleParser(config.ParserConfig())
+	if err != nil {
+		log.Fatalf("Ошибка создания парсера: %v", err)
+	}
+
+	fmt.Fprintln(console, "📚 Получение расписания с tt.audit.msu.ru...")
+
+	// Итог загрузки в schedule.json бот берет из fetch_status.json: так он
+	// знает о неудачах таймера и может сообщить о них админу
+	recordStatus := func(err error) {
+		if *output != ScheduleFile {
+			return
+		}
+		if err := saveFetchRecord(FetchStatusFile, time.Now(), err); err != nil {
+			slog.Warn("⚠️ Не удалось сохранить итог обновления", "file", FetchStatusFile, "err", err)
+		}
+	}
+
+	started := time.Now()
+	schedule, err := scheduleParser.FetchSchedule()
+	if err != nil {
+		recordStatus(err)
+		slog.Error("❌ Ошибка получения расписания", "step", fetchErrorKind(err),
+			"duration", time.Since(started).Round(time.Millisecond), "err", err)
+		os.Exit(1)
+	}
+	// Не перезаписываем рабочий файл результатом сломанного разбора
+	if err := parser.Validate(schedule.Lessons); err != nil {
+		recordStatus(err)
+		log.Fatalf("❌ %v", err)
+	}
+
+	// В архив попадает полная выгрузка, до фильтра по датам
+	if config.HistoryDir != "" {
+		if _, err := NewHistory(config.HistoryDir, config.Location()).Add(schedule); err != nil {
+			recordStatus(fmt.Errorf("ошибка сохранения в архив: %w", err))
+			log.Fatalf("❌ Ошибка сохранения в архив: %v", err)
+		}
+	}
+
+	lessons, err := FilterByDate(schedule.Lessons, *from, *to)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	schedule.Lessons = lessons
+	if *from != "" {
+		schedule.DateStart = *from
+	}
+	if *to != "" {
+		schedule.DateEnd = *to
+	}
+
+	fmt.Fprintf(console, "✅ Найдено занятий: %d\n\n", len(lessons))
+
+	var buf bytes.Buffer
+	if err := WriteSchedule(&buf, *format, schedule, config.Location()); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	if err := writeOutput(*output, buf.Bytes()); err != nil {
+		recordStatus(err)
+		log.Fatalf("❌ %v", err)
+	}
+	recordStatus(nil)
+	if *output == "-" {
+		return
+	}
+
+	fmt.Fprintf(console, "💾 Расписание сохранено в %s (%s)\n", *output, *format)
+
+	// Выводим в читаемом формате
+	if *format != FormatText {
+		fmt.Fprintln(console, "\n=== Расписание ===")
+		writeText(console, lessons)
+	}
+
+	fmt.Fprintln(console, "\n✅ Готово!")
+}
+
+// loadCommandConfig загружает и проверяет конфиг для команд без бота: токен не нужен
+func loadCommandConfig(source *ConfigSource) Config {
+	config, err := LoadConfig(source)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		log.Fatalf("❌ Ошибка конфига:\n%v", err)
+	}
+	setupLogging(config)
+	return config
+}
+
+// Флаги fetch, которые переопределяют параметры конфига
+var fetchConfigFlags = map[string]string{
+	"faculty":    "FACULTY_ID",
+	"course":     "COURSE",
+	"group":      "GROUP_ID",
+	"group-name": "GROUP_NAME",
+	"history":    "HISTORY_DIR",
+}
+
+// runExport конвертирует сохраненное расписание в другой формат без обращения к сайту
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	input := flags.String("i", "schedule.json", "файл расписания, сохраненный командой fetch")
+	output := flags.String("o", "-", "куда записать результат, - для stdout")
+	format := flags.String("format", "", "формат: "+strings.Join(OutputFormats, ", ")+" (по умолчанию по расширению -o)")
+	from := flags.String("from", "", "только пары начиная с даты ДД.ММ.ГГГГ")
+	to := flags.String("to", "", "только пары до даты ДД.ММ.ГГГГ включительно")
+	source := addConfigFlags(flags)
+	flags.Parse(args)
+
+	log.SetFlags(0)
+
+	config := loadCommandConfig(source)
+
+	if *format == "" {
+		*format = FormatFromPath(*output)
+	}
+
+	schedule, err := ReadSchedule(*input)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	// Как и у fetch, границы выгрузки сужаются до -from/-to
+	schedule.Lessons, err = FilterByDate(schedule.Lessons, *from, *to)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if *from != "" {
+		schedule.DateStart = *from
+	}
+	if *to != "" {
+		schedule.DateEnd = *to
+	}
+
+	// Сначала пишем в память, чтобы неизвестный формат не оставил пустой файл
+	var buf bytes.Buffer
+	if err := WriteSchedule(&buf, *format, schedule, config.Location()); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	if err := writeOutput(*output, buf.Bytes()); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+}
+
+// writeOutput пишет расписание в файл или stdout ("-")
+func writeOutput(output string, data []byte) error {
+	if output == "-" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			return fmt.Errorf("ошибка вывода: %w", err)
+		}
+		return nil
+	}
+
+	if err := writeFileAtomic(output, data, 0644); err != nil {
+		return fmt.Errorf("ошибка сохранения в %s: %w", output, err)
+	}
+	return nil
+}
+
+// runDiff сравнивает два файла расписания. Как и diff(1), завершается
+// с кодом 1, если расписания отличаются
+func runDiff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Использование: msuparser diff old.json new.json")
+	}
+	flags.Parse(args)
+
+	log.SetFlags(0)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	old, err := ReadLessons(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	current, err := ReadLessons(flags.Arg(1))
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	diff := DiffSchedules(old, current)
+	if err := WriteDiff(os.Stdout, diff); err != nil {
+		log.Fatalf("❌ Ошибка вывода: %v", err)
+	}
+	if !diff.Empty() {
+		os.Exit(1)
+	}
+}
+
+// runHistory работает с архивом выгрузок: list, show, diff
+func runHistory(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	dir := flags.String("dir", "", "каталог архива (по умолчанию HISTORY_DIR из конфига)")
+	format := flags.String("format", FormatText, "формат для show: "+strings.Join(OutputFormats, ", "))
+	source := addConfigFlags(flags)
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintln(out, "Использование:")
+		fmt.Fprintln(out, "  msuparser history list")
+		fmt.Fprintln(out, "  msuparser history show <снимок>")
+		fmt.Fprintln(out, "  msuparser history diff <снимок> [<снимок>]")
+		fmt.Fprintln(out, "Снимок: номер из list, префикс хэша, дата ДД.ММ.ГГГГ (каким было расписание в этот день) или latest")
+		flags.PrintDefaults()
+	}
+	refs := parseInterspersed(flags, args)
+	action := ""
+	if len(refs) > 0 {
+		action, refs = refs[0], refs[1:]
+	}
+
+	log.SetFlags(0)
+
+	config := loadCommandConfig(source)
+	loc := config.Location()
+	if *dir == "" {
+		*dir = config.HistoryDir
+	}
+	if *dir == "" {
+		*dir = DefaultHistoryDir
+	}
+	history := NewHistory(*dir, loc)
+	load := func(ref string) *parser.Schedule {
+		entry, err := history.Find(ref)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		schedule, err := history.Load(entry)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		return schedule
+	}
+
+	switch action {
+	case "list":
+		entries, err := history.Entries()
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if len(entries) == 0 {
+			fmt.Printf("Архив %s пуст\n", *dir)
+			return
+		}
+		for i, entry := range entries {
+			fmt.Printf("%3d  %s  %s  %3d пар  %s - %s\n",
+				i+1,
+				entry.FetchedAt.In(loc).Format("02.01.2006 15:04"),
+				entry.ShortHash(),
+				entry.Lessons,
+				entry.DateStart,
+				entry.DateEnd,
+			)
+		}
+
+	case "show":
+		if len(refs) != 1 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		schedule := load(refs[0])
+
+		var buf bytes.Buffer
+		if err := WriteSchedule(&buf, *format, schedule, loc); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if err := writeOutput("-", buf.Bytes()); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+
+	case "diff":
+		if len(refs) != 1 && len(refs) != 2 {
+			flags.Usage()
+			os.Exit(2)
+		}
+		newRef := "latest"
+		if len(refs) == 2 {
+			newRef = refs[1]
+		}
+		old := load(refs[0])
+		current := load(newRef)
+
+		if err := WriteDiff(os.Stdout, DiffSchedules(old.Lessons, current.Lessons)); err != nil {
+			log.Fatalf("❌ Ошибка вывода: %v", err)
+		}
+
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// parseInterspersed разбирает флаги, стоящие и до, и после позиционных
+// аргументов (flag.Parse останавливается на первом позиционном)
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		if flags.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
+
+// runGroups печатает ID факультетов, курсов и групп для флагов fetch
+func runGroups(args []string) {
+	flags := flag.NewFlagSet("groups", flag.ExitOnError)
+	source := addConfigFlags(flags)
+	flags.Parse(args)
+
+	log.SetFlags(0)
+	// TIMETABLE_URL и таймауты берутся из того же конфига, что и у fetch
+	config := loadCommandConfig(source)
+
+	scheduleParser, err := parser.NewScheduleParser(config.ParserConfig())
+	if err != nil {
+		log.Fatalf("Ошибка создания парсера: %v", err)
+	}
+
+	options, err := scheduleParser.FormOptions()
+	if err != nil {
+		log.Fatalf("❌ Ошибка загрузки формы расписания: %v", err)
+	}
+
+	sections := []struct{ field, title, flag string }{
+		{"facultyId", "Факультеты", "-faculty"},
+		{"course", "Курсы", "-course"},
+		{"groupId", "Группы", "-group"},
+	}
+	for _, section := range sections {
+		fmt.Printf("%s (%s):\n", section.title, section.flag)
+		if len(options[section.field]) == 0 {
+			fmt.Println("  сайт не отдал список без выбора факультета и курса")
+		}
+		for _, option := range options[section.field] {
+			fmt.Printf("  %-6s %s\n", option.Value, option.Label)
+		}
+		fmt.Println()
+	}
+}
+
+// runValidateConfig проверяет конфиг бота (файл, окружение и -set) и
+// завершается с ошибкой, если он неверный
+func runValidateConfig(args []string) {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	source := addConfigFlags(flags)
+	flags.Parse(args)
+
+	config, err := LoadConfig(source)
+	if err != nil {
+		fmt.Printf("❌ Ошибка загрузки конфига: %v\n", err)
+		os.Exit(1)
+	}
+	if err := config.ValidateBot(); err != nil {
+		fmt.Printf("❌ Ошибки в конфиге:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	path, _ := source.File()
+	fmt.Printf("✅ Конфигурация в порядке (%s, группа %d/%d/%d, %s)\n",
+		path, config.FacultyID, config.Course, config.GroupID, config.Timezone)
+}
+
+// runServe раздает календари по HTTP без Telegram бота. Расписание и
+// подписчики перечитываются, когда их файлы меняются
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := flags.String("listen", "", "адрес HTTP сервера (по умолчанию HTTP_LISTEN из конфига или :8080)")
+	input := flags.String("i", "schedule.json", "файл расписания")
+	source := addConfigFlags(flags)
+	flags.Parse(args)
+
+	// Из конфига нужны только общие фильтры и адрес, токен бота не обязателен
+	config, err := LoadConfig(source)
+	if err == nil {
+		err = config.Validate()
+	}
+	if err != nil {
+		fmt.Printf("❌ Ошибка конфига:\n%v\n", err)
+		os.Exit(1)
+	}
+	setupLogging(config)
+
+	bot := NewTimetableBot(nil, "")
+	bot.applyConfig(config)
+	bot.httpListen = *listen
+	if bot.httpListen == "" {
+		bot.httpListen = config.HTTPListen
+	}
+	if bot.httpListen == "" {
+		bot.httpListen = ":8080"
+	}
+	bot.metricsListen = config.MetricsListen
+
+	if err := bot.LoadSchedule(*input); err != nil {
+		os.Exit(1)
+	}
+	if err := bot.subscribers.Load(); err != nil {
+		slog.Error("❌ Ошибка загрузки подписчиков", "file", SubscribersFile, "err", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := shutdownContext()
+	defer stop()
+
+	bot.beat()
+	bot.StartHTTPServer(ctx)
+	bot.StartMetricsServer(ctx)
+	sdNotify("READY=1")
+
+	ticker := time.NewTicker(CheckInterval)
+	defer ticker.Stop()
+
+	scheduleModTime := fileModTime(*input)
+	subscribersModTime := fileModTime(SubscribersFile)
+	for {
+		select {
+		case <-ticker.C:
+			bot.beat()
+			if modTime := fileModTime(*input); !modTime.Equal(scheduleModTime) {
+				if err := bot.LoadSchedule(*input); err == nil {
+					scheduleModTime = modTime
+				}
+			}
+			if modTime := fileModTime(SubscribersFile); !modTime.Equal(subscribersModTime) {
+				if err := bot.subscribers.Load(); err != nil {
+					slog.Warn("⚠️ Ошибка загрузки подписчиков", "file", SubscribersFile, "err", err)
+				} else {
+					subscribersModTime = modTime
+				}
+			}
+		case <-ctx.Done():
+			slog.Info("⏹️  Останавливаю сервер")
+			sdNotify("STOPPING=1")
+			deadline, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+			defer cancel()
+			if !waitContext(deadline, &bot.background) {
+				slog.Warn("⚠️ Не все запросы завершились за отведенное время", "timeout", ShutdownTimeout)
+			}
+			slog.Info("⏹️  Сервер остановлен")
+			return
+		}
+	}
+}
+
+// fileModTime возвращает время изменения файла или нулевое время, если его нет
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// runBot запускает Telegram бота
+func runBot(args []string) {
+	flags := flag.NewFlagSet("bot", flag.ExitOnError)
+	source := addConfigFlags(flags)
+	fakeNow := flags.String("fake-now", "", "запустить часы бота с момента ДД.ММ.ГГГГ ЧЧ:ММ, для проверки напоминаний и обновления в 2:00")
+	flags.Parse(args)
+
+	config, err := LoadConfig(source)
+	if err != nil {
+		fmt.Printf("❌ Ошибка загрузки конфига: %v\n", err)
+		os.Exit(1)
+	}
+	if err := config.ValidateBot(); err != nil {
+		fmt.Printf("❌ Ошибки в конфиге:\n%v\n", err)
+		os.Exit(1)
+	}
+	setupLogging(config)
+	path, _ := source.File()
+	slog.Info("⚙️  Конфигурация загружена", "file", path, "log_level", config.LogLevel, "log_format", config.LogFormat)
+
+	// Параметры запуска; остальное применяет applyConfig, в том числе при изменении config.json
+	bot := NewTimetableBot(NewTelegramClient(config.TelegramAPIURL, config.BotToken, nil), config.UserID)
+	bot.httpListen = config.HTTPListen
+	bot.metricsListen = config.MetricsListen
+	if config.UpdatesMode == UpdatesModeWebhook {
+		bot.updatesMode = UpdatesModeWebhook
+		bot.webhook = config.Webhook()
+	}
+	if *fakeNow != "" {
+		start, err := parseClockStart(*fakeNow, config.Location())
+		if err != nil {
+			fmt.Printf("❌ -fake-now: %v\n", err)
+			os.Exit(1)
+		}
+		bot.clock = newOffsetClock(start)
+		slog.Info("🕰️  Часы бота переведены", "start", start.Format("02.01.2006 15:04"))
+	}
+	bot.applyConfig(config)
+	bot.configSource = source
+	bot.configWatcher = newFileWatcher(path)
+
+	ctx, stop := shutdownContext()
+	defer stop()
+	if err := bot.Run(ctx); err != nil {
+		// Ненулевой код, чтобы systemd перезапустил бота
+		slog.Error("❌ Бот завершился с ошибкой", "err", err)
+		stop()
+		os.Exit(1)
+	}
+}
+
+// shutdownContext возвращает корневой контекст, который отменяется по
+// SIGINT или SIGTERM. Повторный сигнал завершает процесс сразу, не
+// дожидаясь аккуратной остановки
+func shutdownContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, stop
+}
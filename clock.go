@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	// База часовых поясов внутри бинарника: TIMEZONE работает и на сервере без tzdata
+	_ "time/tzdata"
+)
+
+// DefaultTimezone часовой пояс расписания МГУ
+const DefaultTimezone = "Europe/Moscow"
+
+// Clock источник текущего времени. Планировщик берет время только отсюда,
+// поэтому утреннюю сводку, напоминания и обновление в 2:00 можно проверить
+// на заданном времени, не дожидаясь его
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock настоящее время
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// offsetClock идет с обычной скоростью, но начинает с заданного момента
+type offsetClock struct {
+	offset time.Duration
+}
+
+// newOffsetClock создает часы, которые сейчас показывают start
+func newOffsetClock(start time.Time) offsetClock {
+	return offsetClock{offset: time.Until(start)}
+}
+
+func (c offsetClock) Now() time.Time { return time.Now().Add(c.offset) }
+
+// parseClockStart разбирает момент "ДД.ММ.ГГГГ ЧЧ:ММ" в часовом поясе расписания
+func parseClockStart(value string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation("02.01.2006 15:04", value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ожидается время в формате ДД.ММ.ГГГГ ЧЧ:ММ, получено %q", value)
+	}
+	return t, nil
+}
+
+// defaultLocation часовой пояс по умолчанию, пока конфиг не загружен
+func defaultLocation() *time.Location {
+	loc, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		// Не случится: база часовых поясов встроена в бинарник
+		panic(err)
+	}
+	return loc
+}
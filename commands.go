@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"msuparser/parser"
+)
+
+const settingsHelp = "⚙️ <b>Команды настройки:</b>\n" +
+	"/lead 20 - напоминать за 20 минут до пары\n" +
+	"/digest on|off - утреннее сообщение о дистанционных парах\n" +
+	"/distance on|off - напоминания перед каждой дистанционной парой\n" +
+	"/changes on|off - сообщать, если пара из напоминания изменилась\n" +
+	"/quiet 23:00-08:00 - тихие часы (/quiet off - выключить)\n" +
+	"/settings - текущие настройки\n\n" +
+	"🙈 <b>Фильтры:</b>\n" +
+	"/mute subject Английский - скрыть предмет (также type, teacher, subgroup)\n" +
+	"/filters - список фильтров, /unmute 1 - удалить фильтр\n\n" +
+	"📅 /today, /tomorrow - расписание с учетом фильтров\n" +
+	"📆 /calendar - ссылка на календарь (iCal) с учетом фильтров\n" +
+	"📊 /status - насколько свежее расписание\n" +
+	"💬 /topic - в группе с темами: присылать сообщения в эту тему\n" +
+	"/stop - отписаться от уведомлений"
+
+// setupCommands меняют настройки чата. В группе их выполняют только ее
+// администраторы и админы бота, смотреть расписание и настройки могут все
+var setupCommands = map[string]bool{
+	"/start":    true,
+	"/stop":     true,
+	"/lead":     true,
+	"/digest":   true,
+	"/distance": true,
+	"/changes":  true,
+	"/quiet":    true,
+	"/mute":     true,
+	"/unmute":   true,
+	"/calendar": true,
+	"/topic":    true,
+}
+
+// handleCommand обрабатывает команду бота из сообщения msg
+func (bot *TimetableBot) handleCommand(msg Message, command string, args []string) {
+	chatID := msg.Chat.ID
+	if msg.Chat.IsGroup() && setupCommands[command] && !bot.canConfigureChat(msg) {
+		bot.SendMessageToChat(chatID, "⛔ Настраивать бота в группе могут только ее администраторы")
+		return
+	}
+
+	switch command {
+	case "/start":
+		bot.commandStart(chatID)
+	case "/stop":
+		bot.commandStop(chatID)
+	case "/settings", "/help":
+		bot.commandSettings(chatID)
+	case "/lead":
+		bot.commandLead(chatID, args)
+	case "/digest":
+		bot.commandToggle(chatID, args, "Утреннее сообщение", func(s *ChatSettings, on bool) {
+			s.MorningDigest = on
+		})
+	case "/distance":
+		bot.commandToggle(chatID, args, "Напоминания о дистанционных парах", func(s *ChatSettings, on bool) {
+			s.DistanceReminders = on
+		})
+	case "/changes":
+		bot.commandToggle(chatID, args, "Сообщения об изменениях пар", func(s *ChatSettings, on bool) {
+			s.ChangeAlerts = on
+		})
+	case "/quiet":
+		bot.commandQuiet(chatID, args)
+	case "/mute":
+		bot.commandMute(chatID, args)
+	case "/unmute":
+		bot.commandUnmute(chatID, args)
+	case "/filters":
+		bot.commandFilters(chatID)
+	case "/today":
+		bot.commandDay(chatID, 0)
+	case "/tomorrow":
+		bot.commandDay(chatID, 1)
+	case "/calendar":
+		bot.commandCalendar(chatID, args)
+	case "/status":
+		bot.commandStatus(chatID, msg.SenderID())
+	case "/topic":
+		bot.commandTopic(msg, args)
+	}
+}
+
+// canConfigureChat проверяет, что отправитель может менять настройки группы:
+// он админ бота, администратор группы или пишет от имени группы анонимно
+func (bot *TimetableBot) canConfigureChat(msg Message) bool {
+	if msg.SenderChat != nil && msg.SenderChat.ID == msg.Chat.ID {
+		return true
+	}
+	if msg.From == nil {
+		return false
+	}
+	if bot.isAdmin(msg.From.ID) {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	member, err := bot.telegram.GetChatMember(ctx, msg.Chat.ID, msg.From.ID)
+	if err != nil {
+		slog.Warn("⚠️ Не удалось проверить права в группе", "chat_id", msg.Chat.ID, "user_id", msg.From.ID, "err", err)
+		return false
+	}
+	return member.IsAdmin()
+}
+
+func (bot *TimetableBot) commandStart(chatID int64) {
+	settings, err := bot.subscribers.Subscribe(chatID)
+	if err != nil {
+		slog.Warn("⚠️ Не удалось сохранить подписчика", "chat_id", chatID, "err", err)
+	}
+
+	idLabel := "Твой ID"
+	if chatID < 0 {
+		idLabel = "ID группы"
+	}
+	welcomeMsg := "👋 Привет! Я бот расписания МГУ ВШГА.\n\n" +
+		fmt.Sprintf("Я буду присылать уведомления за %d минут до начала пар.\n", settings.LeadMinutes) +
+		"Расписание обновляется автоматически каждую ночь.\n\n" +
+		idLabel + ": " + fmt.Sprintf("%d", chatID) + "\n\n" +
+		settingsHelp
+
+	bot.SendMessageToChat(chatID, welcomeMsg)
+}
+
+func (bot *TimetableBot) commandStop(chatID int64) {
+	if err := bot.subscribers.Unsubscribe(chatID); err != nil {
+		slog.Warn("⚠️ Не удалось удалить подписчика", "chat_id", chatID, "err", err)
+	}
+	bot.SendMessageToChat(chatID, "🔕 Уведомления отключены. Чтобы снова подписаться, отправь /start")
+}
+
+func (bot *TimetableBot) commandSettings(chatID int64) {
+	settings, ok := bot.subscribers.Get(chatID)
+	if !ok {
+		bot.SendMessageToChat(chatID, "Ты не подписан на уведомления. Отправь /start")
+		return
+	}
+	bot.SendMessageToChat(chatID, formatSettings(settings)+"\n\n"+settingsHelp)
+}
+
+func (bot *TimetableBot) commandLead(chatID int64, args []string) {
+	if len(args) != 1 {
+		bot.SendMessageToChat(chatID, "Использование: /lead 20")
+		return
+	}
+
+	minutes, err := strconv.Atoi(args[0])
+	if err != nil || minutes < 1 || minutes > 180 {
+		bot.SendMessageToChat(chatID, "❌ Укажи число минут от 1 до 180")
+		return
+	}
+
+	bot.updateSettings(chatID, fmt.Sprintf("✅ Буду напоминать за %d минут до пары", minutes), func(s *ChatSettings) {
+		s.LeadMinutes = minutes
+		s.LeadCustom = true
+	})
+}
+
+func (bot *TimetableBot) commandToggle(chatID int64, args []string, title string, apply func(*ChatSettings, bool)) {
+	on, ok := parseSwitch(args)
+	if !ok {
+		bot.SendMessageToChat(chatID, "Использование: on или off")
+		return
+	}
+
+	bot.updateSettings(chatID, fmt.Sprintf("✅ %s: %s", title, formatSwitch(on)), func(s *ChatSettings) {
+		apply(s, on)
+	})
+}
+
+func (bot *TimetableBot) commandQuiet(chatID int64, args []string) {
+	if len(args) == 1 && strings.ToLower(args[0]) == "off" {
+		bot.updateSettings(chatID, "✅ Тихие часы выключены", func(s *ChatSettings) {
+			s.QuietStart, s.QuietEnd = "", ""
+		})
+		return
+	}
+
+	bounds := []string{}
+	if len(args) == 1 {
+		bounds = strings.Split(args[0], "-")
+	}
+	if len(bounds) != 2 {
+		bot.SendMessageToChat(chatID, "Использование: /quiet 23:00-08:00 или /quiet off")
+		return
+	}
+	for _, bound := range bounds {
+		if _, err := parseClock(bound); err != nil {
+			bot.SendMessageToChat(chatID, "❌ "+err.Error())
+			return
+		}
+	}
+
+	reply := fmt.Sprintf("✅ Тихие часы: %s-%s", bounds[0], bounds[1])
+	bot.updateSettings(chatID, reply, func(s *ChatSettings) {
+		s.QuietStart, s.QuietEnd = bounds[0], bounds[1]
+	})
+}
+
+func (bot *TimetableBot) commandMute(chatID int64, args []string) {
+	if len(args) < 2 {
+		bot.SendMessageToChat(chatID, "Использование: /mute subject|type|teacher|subgroup значение")
+		return
+	}
+
+	filter, err := ParseLessonFilter(args[0], strings.Join(args[1:], " "))
+	if err != nil {
+		bot.SendMessageToChat(chatID, "❌ "+err.Error())
+		return
+	}
+
+	bot.updateSettings(chatID, "🙈 Скрываю пары: "+html.EscapeString(filter.String()), func(s *ChatSettings) {
+		s.Filters = append(s.Filters, filter)
+	})
+}
+
+func (bot *TimetableBot) commandUnmute(chatID int64, args []string) {
+	if len(args) != 1 {
+		bot.SendMessageToChat(chatID, "Использование: /unmute номер (из /filters) или /unmute all")
+		return
+	}
+
+	if strings.ToLower(args[0]) == "all" {
+		bot.updateSettings(chatID, "✅ Все фильтры удалены", func(s *ChatSettings) {
+			s.Filters = nil
+		})
+		return
+	}
+
+	settings, ok := bot.subscribers.Get(chatID)
+	if !ok {
+		bot.SendMessageToChat(chatID, "Сначала подпишись на уведомления: /start")
+		return
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(settings.Filters) {
+		bot.SendMessageToChat(chatID, "❌ Нет фильтра с таким номером, смотри /filters")
+		return
+	}
+
+	removed := settings.Filters[n-1]
+	bot.updateSettings(chatID, "✅ Фильтр удален: "+html.EscapeString(removed.String()), func(s *ChatSettings) {
+		filters := []LessonFilter{}
+		for _, filter := range s.Filters {
+			if filter != removed {
+				filters = append(filters, filter)
+			}
+		}
+		s.Filters = filters
+	})
+}
+
+func (bot *TimetableBot) commandFilters(chatID int64) {
+	settings, _ := bot.subscribers.Get(chatID)
+	global := bot.runtime().config.Filters
+
+	if len(settings.Filters) == 0 && len(global) == 0 {
+		bot.SendMessageToChat(chatID, "Фильтров нет. Добавить: /mute subject Английский")
+		return
+	}
+
+	message := "🙈 <b>Скрытые пары</b>\n"
+	for i, filter := range settings.Filters {
+		message += fmt.Sprintf("%d. %s\n", i+1, html.EscapeString(filter.String()))
+	}
+	if len(global) > 0 {
+		message += "\n<b>Общие (из конфига):</b>\n"
+		for _, filter := range global {
+			message += "• " + html.EscapeString(filter.String()) + "\n"
+		}
+	}
+
+	bot.SendMessageToChat(chatID, message)
+}
+
+// commandDay показывает расписание на сегодня (offset 0) или завтра (offset 1)
+func (bot *TimetableBot) commandDay(chatID int64, offset int) {
+	settings, ok := bot.subscribers.Get(chatID)
+	if !ok {
+		settings = DefaultChatSettings(chatID, bot.runtime().config.NotificationMinutes)
+	}
+
+	date := bot.now().AddDate(0, 0, offset).Format("02.01.2006")
+
+	var dayLessons []parser.Lesson
+	for _, lesson := range bot.Schedule() {
+		if lesson.Date == date {
+			dayLessons = append(dayLessons, lesson)
+		}
+	}
+
+	visible := settings.VisibleLessons(dayLessons, bot.runtime().config.Filters)
+	hidden := len(dayLessons) - len(visible)
+
+	bot.SendMessageToChat(chatID, formatDay(date, visible, hidden))
+}
+
+// commandCalendar выдает личную ссылку на календарь, /calendar reset - новую ссылку
+func (bot *TimetableBot) commandCalendar(chatID int64, args []string) {
+	if bot.httpListen == "" {
+		bot.SendMessageToChat(chatID, "📆 Календарь по ссылке не настроен на этом сервере")
+		return
+	}
+
+	settings, ok := bot.subscribers.Get(chatID)
+	if !ok {
+		bot.SendMessageToChat(chatID, "Сначала подпишись на уведомления: /start")
+		return
+	}
+
+	reset := len(args) == 1 && strings.ToLower(args[0]) == "reset"
+	token := settings.FeedToken
+	if token == "" || reset {
+		var err error
+		token, err = newFeedToken()
+		if err != nil {
+			slog.Warn("⚠️ Не удалось создать токен календаря", "chat_id", chatID, "err", err)
+			return
+		}
+		if _, err := bot.subscribers.Update(chatID, func(s *ChatSettings) { s.FeedToken = token }); err != nil {
+			slog.Warn("⚠️ Не удалось сохранить токен календаря", "chat_id", chatID, "err", err)
+			return
+		}
+	}
+
+	bot.SendMessageToChat(chatID, "📆 <b>Твой календарь</b>\n\n"+
+		html.EscapeString(bot.feedURL(token))+"\n\n"+
+		"Добавь ссылку как подписку в Google/Apple Calendar. Фильтры из /filters применяются.\n"+
+		"Не делись ссылкой; /calendar reset выдаст новую, а старая перестанет работать.")
+}
+
+// commandTopic выбирает тему форума, в которую бот пишет в группе:
+// ту, где отправлена команда. /topic off - обратно в общую тему
+func (bot *TimetableBot) commandTopic(msg Message, args []string) {
+	if !msg.Chat.IsForum {
+		bot.SendMessageToChat(msg.Chat.ID, "💬 Темы есть только в группах, где они включены")
+		return
+	}
+
+	thread := 0
+	if msg.IsTopicMessage {
+		thread = msg.MessageThreadID
+	}
+	if len(args) == 1 && strings.ToLower(args[0]) == "off" {
+		thread = 0
+	}
+
+	reply := "💬 Буду писать в эту тему"
+	if thread == 0 {
+		reply = "💬 Буду писать в общую тему"
+	}
+	bot.updateSettings(msg.Chat.ID, reply, func(s *ChatSettings) {
+		s.ThreadID = thread
+	})
+}
+
+// updateSettings применяет изменение к настройкам чата и отвечает reply
+func (bot *TimetableBot) updateSettings(chatID int64, reply string, change func(*ChatSettings)) {
+	if _, err := bot.subscribers.Update(chatID, change); err != nil {
+		slog.Warn("⚠️ Не удалось обновить настройки", "chat_id", chatID, "err", err)
+		bot.SendMessageToChat(chatID, "Сначала подпишись на уведомления: /start")
+		return
+	}
+	bot.SendMessageToChat(chatID, reply)
+}
+
+func formatSettings(s ChatSettings) string {
+	quiet := "выключены"
+	if s.QuietStart != "" && s.QuietEnd != "" {
+		quiet = s.QuietStart + "-" + s.QuietEnd
+	}
+
+	lead := fmt.Sprintf("%d мин", s.LeadMinutes)
+	if !s.LeadCustom && s.LeadMinutes < thirdLessonMinLead {
+		lead += fmt.Sprintf(" (перед 3 парой - за %d)", thirdLessonMinLead)
+	}
+
+	return fmt.Sprintf(
+		"⚙️ <b>Твои настройки</b>\n\n"+
+			"🔔 Напоминание за: %s\n"+
+			"📱 Утреннее сообщение о дистанционных парах: %s\n"+
+			"💻 Напоминания перед дистанционными парами: %s\n"+
+			"✏️ Сообщения об изменениях пар: %s\n"+
+			"🌙 Тихие часы: %s",
+		lead,
+		formatSwitch(s.MorningDigest),
+		formatSwitch(s.DistanceReminders),
+		formatSwitch(s.ChangeAlerts),
+		quiet,
+	) + formatTopic(s)
+}
+
+// formatTopic строка о теме форума для настроек группы
+func formatTopic(s ChatSettings) string {
+	if s.ThreadID == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n💬 Тема форума: %d", s.ThreadID)
+}
+
+// formatDay форматирует список пар за день
+func formatDay(date string, lessons []parser.Lesson, hidden int) string {
+	if len(lessons) == 0 {
+		message := fmt.Sprintf("📅 <b>%s</b>\n\nПар нет 🎉", date)
+		if hidden > 0 {
+			message += fmt.Sprintf("\n\n🙈 Скрыто фильтрами: %d", hidden)
+		}
+		return message
+	}
+
+	message := fmt.Sprintf("📅 <b>%s (%s)</b>\n\n", date, lessons[0].Weekday)
+	for _, lesson := range lessons {
+		message += fmt.Sprintf("<b>%s пара</b> (%s-%s)\n📚 %s\n",
+			lesson.LessonNumber, lesson.TimeStart, lesson.TimeEnd, html.EscapeString(lesson.Subject))
+		if lesson.Teacher != "" {
+			message += fmt.Sprintf("👨‍🏫 %s\n", html.EscapeString(lesson.Teacher))
+		}
+		if lesson.Room != "" {
+			message += fmt.Sprintf("🚪 %s\n", html.EscapeString(lesson.Room))
+		}
+		message += "\n"
+	}
+	if hidden > 0 {
+		message += fmt.Sprintf("🙈 Скрыто фильтрами: %d", hidden)
+	}
+
+	return strings.TrimSpace(message)
+}
+
+func parseSwitch(args []string) (on bool, ok bool) {
+	if len(args) != 1 {
+		return false, false
+	}
+	switch strings.ToLower(args[0]) {
+	case "on", "вкл", "1":
+		return true, true
+	case "off", "выкл", "0":
+		return false, true
+	}
+	return false, false
+}
+
+func formatSwitch(on bool) string {
+	if on {
+		return "вкл"
+	}
+	return "выкл"
+}
+
+func (bot *TimetableBot) commandStatus(chatID, from int64) {
+	bot.scheduleMu.RLock()
+	schedule := bot.schedule
+	bot.scheduleMu.RUnlock()
+
+	now := bot.now()
+	loc := bot.runtime().location
+	status := bot.fetchHealth.Status()
+
+	var b strings.Builder
+	b.WriteString("📊 <b>Статус</b>\n\n")
+
+	if schedule.FetchedAt.IsZero() {
+		fmt.Fprintf(&b, "📚 Расписание: %d пар, время получения неизвестно\n", len(schedule.Lessons))
+	} else {
+		age := now.Sub(schedule.FetchedAt)
+		mark := "✅"
+		if age > status.StaleAfter {
+			mark = "⚠️"
+		}
+		fmt.Fprintf(&b, "%s Расписание: %d пар, получено %s (%s назад)\n",
+			mark, len(schedule.Lessons), schedule.FetchedAt.In(loc).Format("02.01.2006 15:04"), formatAge(age))
+	}
+	if schedule.DateStart != "" {
+		fmt.Fprintf(&b, "📅 Период: %s - %s\n", schedule.DateStart, schedule.DateEnd)
+	}
+
+	if !status.LastAttempt.IsZero() {
+		fmt.Fprintf(&b, "🔄 Последняя попытка обновления: %s\n", status.LastAttempt.In(loc).Format("02.01.2006 15:04"))
+	}
+	if status.Failures > 0 {
+		fmt.Fprintf(&b, "❌ Неудачных попыток подряд: %d (%s)\n<code>%s</code>\n",
+			status.Failures, fetchErrorNames[status.LastKind], html.EscapeString(status.LastError.Error()))
+	}
+
+	fmt.Fprintf(&b, "👥 Подписчиков: %d\n", len(bot.subscribers.All()))
+	fmt.Fprintf(&b, "📤 В очереди сообщений: %d", bot.outbox.Pending())
+
+	// Ошибки и ближайшие напоминания других чатов не для группы: только
+	// в личном чате админа, ID которого совпадает с ID пользователя
+	if chatID == from && bot.isAdmin(from) {
+		bot.writeAdminStatus(&b, loc)
+	}
+
+	bot.SendMessageToChat(chatID, b.String())
+}
+
+// writeAdminStatus дописывает к /status то, что нужно только админу:
+// версию, время работы, ближайшие напоминания и последние ошибки подсистем
+func (bot *TimetableBot) writeAdminStatus(b *strings.Builder, loc *time.Location) {
+	b.WriteString("\n\n🛠 <b>Для админа</b>\n")
+	fmt.Fprintf(b, "🏷 Версия: <code>%s</code>\n", html.EscapeString(buildInfo().String()))
+	fmt.Fprintf(b, "⏱ Работает: %s (с %s)\n", formatAge(time.Since(bot.startedAt)), bot.startedAt.In(loc).Format("02.01.2006 15:04"))
+
+	if bot.telegram != nil {
+		if health := bot.telegram.Health(); health.Reachable {
+			b.WriteString("🌐 Telegram: ✅ доступен\n")
+		} else if health.LastError != nil {
+			fmt.Fprintf(b, "🌐 Telegram: ❌ <code>%s</code>\n", html.EscapeString(health.LastError.Error()))
+		}
+	}
+
+	b.WriteString("\n🔔 <b>Ближайшие напоминания:</b>\n")
+	planned := bot.upcomingNotifications(5)
+	if len(planned) == 0 {
+		b.WriteString("нет\n")
+	}
+	for _, p := range planned {
+		fmt.Fprintf(b, "• %s %s (чатов: %d)\n",
+			p.At.In(loc).Format("02.01 15:04"), html.EscapeString(p.Lesson.Subject), p.Chats)
+	}
+
+	errs := bot.errors.All()
+	if bot.telegram != nil {
+		if health := bot.telegram.Health(); health.LastError != nil {
+			errs[SubsystemTelegram] = subsystemError{At: health.LastErrorAt, Err: health.LastError.Error()}
+		}
+	}
+
+	b.WriteString("\n🧯 <b>Последние ошибки:</b>")
+	if len(errs) == 0 {
+		b.WriteString("\nнет")
+	}
+	for _, subsystem := range subsystems {
+		last, ok := errs[subsystem]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(b, "\n• %s, %s:\n<code>%s</code>", subsystemNames[subsystem], last.At.In(loc).Format("02.01.2006 15:04"), html.EscapeString(last.Err))
+	}
+}
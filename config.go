@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"msuparser/parser"
+)
+
+const ConfigFile = "config.json"
+
+// ConfigPathEnv переменная окружения с путем к конфигу, если он не в рабочем каталоге
+const ConfigPathEnv = "MSUPARSER_CONFIG"
+
+// Имя файла с токеном в каталоге systemd credentials (LoadCredential=bot_token:...)
+const botTokenCredential = "bot_token"
+
+type Config struct {
+	BotToken            string         `json:"BOT_TOKEN"`
+	BotTokenFile        string         `json:"BOT_TOKEN_FILE"` // Файл с токеном вместо BOT_TOKEN
+	UserID              string         `json:"USER_ID"`
+	Admins              []int64        `json:"ADMINS"` // Кому еще, кроме USER_ID, доступны команды админа
+	NotificationMinutes int            `json:"NOTIFICATION_MINUTES"`
+	TelegramAPIURL      string         `json:"TELEGRAM_API_URL"` // Для локального Bot API сервера или заглушки
+	Filters             []LessonFilter `json:"FILTERS"`
+
+	// Чье расписание загружать (ID - командой groups), в каком часовом поясе
+	// и на сколько дней вперед
+	FacultyID    int    `json:"FACULTY_ID"`
+	Course       int    `json:"COURSE"`
+	GroupID      int    `json:"GROUP_ID"`
+	GroupName    string `json:"GROUP_NAME"` // Название группы в календарях и ID пар
+	Timezone     string `json:"TIMEZONE"`
+	ScheduleDays int    `json:"SCHEDULE_DAYS"`
+	TimetableURL string `json:"TIMETABLE_URL"` // Для зеркала сайта или заглушки
+
+	// Прием обновлений: "polling" (по умолчанию) или "webhook"
+	UpdatesMode   string `json:"UPDATES_MODE"`
+	WebhookURL    string `json:"WEBHOOK_URL"`
+	WebhookListen string `json:"WEBHOOK_LISTEN"`
+	WebhookSecret string `json:"WEBHOOK_SECRET"`
+	WebhookCert   string `json:"WEBHOOK_CERT"`
+	WebhookKey    string `json:"WEBHOOK_KEY"`
+
+	// HTTP сервер с календарями; FEED_BASE_URL - внешний адрес для ссылок в /calendar
+	HTTPListen  string `json:"HTTP_LISTEN"`
+	FeedBaseURL string `json:"FEED_BASE_URL"`
+
+	// /metrics, /healthz и /readyz; по умолчанию только локально, пусто - выключено
+	MetricsListen string `json:"METRICS_LISTEN"`
+
+	// Выгрузка в CalDAV коллекцию, например https://dav.example.com/user/timetable/
+	CalDAVURL      string `json:"CALDAV_URL"`
+	CalDAVUsername string `json:"CALDAV_USERNAME"`
+	CalDAVPassword string `json:"CALDAV_PASSWORD"`
+
+	// Предупреждения о сбоях обновления расписания: чат админа (по умолчанию
+	// USER_ID) и через сколько часов без обновления расписание считается устаревшим
+	AdminChatID     string `json:"ADMIN_CHAT_ID"`
+	StaleAfterHours int    `json:"STALE_AFTER_HOURS"`
+
+	// Каталог архива выгрузок расписания (по умолчанию "history")
+	HistoryDir string `json:"HISTORY_DIR"`
+
+	// Журнал: уровень (debug, info, warn, error) и формат (console, text, json)
+	LogLevel  string `json:"LOG_LEVEL"`
+	LogFormat string `json:"LOG_FORMAT"`
+}
+
+// DefaultConfig значения параметров, которых нет ни в файле, ни в окружении.
+// Группа 303 - пример из ТЗ
+func DefaultConfig() Config {
+	return Config{
+		NotificationMinutes: 15,
+		FacultyID:           3,
+		Course:              3,
+		GroupID:             52,
+		GroupName:           "303",
+		Timezone:            DefaultTimezone,
+		ScheduleDays:        31,
+		UpdatesMode:         UpdatesModePolling,
+		StaleAfterHours:     int(DefaultStaleAfter / time.Hour),
+		HistoryDir:          DefaultHistoryDir,
+		LogLevel:            "info",
+		LogFormat:           LogFormatConsole,
+		MetricsListen:       DefaultMetricsListen,
+	}
+}
+
+// ConfigSource откуда собирается конфиг. Слои по возрастанию приоритета:
+// значения по умолчанию, файл, переменные окружения с именами ключей
+// (BOT_TOKEN, NOTIFICATION_MINUTES, ...) и флаги командной строки
+type ConfigSource struct {
+	Path      string   // Пусто - MSUPARSER_CONFIG или config.json
+	Overrides []string // KEY=VALUE из флагов
+}
+
+// addConfigFlags добавляет подкоманде флаги -config и -set
+func addConfigFlags(flags *flag.FlagSet) *ConfigSource {
+	source := &ConfigSource{}
+	flags.StringVar(&source.Path, "config", "", "файл конфига (по умолчанию $"+ConfigPathEnv+" или "+ConfigFile+")")
+	flags.Func("set", "переопределить параметр конфига: -set KEY=VALUE, можно несколько раз", func(value string) error {
+		source.Overrides = append(source.Overrides, value)
+		return nil
+	})
+	return source
+}
+
+// File возвращает путь к файлу конфига. Файл, указанный явно, обязан существовать,
+// а без config.json в рабочем каталоге можно обойтись переменными окружения
+func (s *ConfigSource) File() (path string, required bool) {
+	if s.Path != "" {
+		return s.Path, true
+	}
+	if path := os.Getenv(ConfigPathEnv); path != "" {
+		return path, true
+	}
+	return ConfigFile, false
+}
+
+// LoadConfig собирает конфиг из всех слоев. Проверка значений - в Validate
+func LoadConfig(source *ConfigSource) (Config, error) {
+	config := DefaultConfig()
+
+	path, required := source.File()
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := decodeConfigFile(data, &config); err != nil {
+			return Config{}, fmt.Errorf("ошибка в %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && !required:
+	default:
+		return Config{}, fmt.Errorf("не могу прочитать конфиг: %w", err)
+	}
+
+	for _, key := range configKeys() {
+		if value, ok := os.LookupEnv(key); ok {
+			if err := setConfigKey(&config, key, value); err != nil {
+				return Config{}, fmt.Errorf("переменная окружения %w", err)
+			}
+		}
+	}
+
+	for _, override := range source.Overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("-set %q: ожидается KEY=VALUE", override)
+		}
+		if err := setConfigKey(&config, key, value); err != nil {
+			return Config{}, fmt.Errorf("-set %w", err)
+		}
+	}
+
+	if err := config.resolveBotToken(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// decodeConfigFile разбирает JSON поверх значений по умолчанию. Опечатка
+// в имени ключа - ошибка, а не молча проигнорированный параметр
+func decodeConfigFile(data []byte, config *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(config)
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &syntaxErr):
+		line := bytes.Count(data[:syntaxErr.Offset], []byte("\n")) + 1
+		return fmt.Errorf("строка %d: %v", line, syntaxErr)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("%s: ожидается %s, а не %s", typeErr.Field, configTypeName(typeErr.Type), jsonValueNames[typeErr.Value])
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return fmt.Errorf("неизвестный параметр %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	return err
+}
+
+// jsonValueNames названия значений JSON из json.UnmarshalTypeError
+var jsonValueNames = map[string]string{
+	"string": "строка",
+	"number": "число",
+	"bool":   "true/false",
+	"array":  "список",
+	"object": "объект",
+	"null":   "null",
+}
+
+// configTypeName название типа параметра для сообщений об ошибках
+func configTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int:
+		return "число"
+	case reflect.String:
+		return "строка"
+	case reflect.Slice:
+		return "список"
+	}
+	return t.String()
+}
+
+// configKeys возвращает ключи конфига в порядке полей Config
+func configKeys() []string {
+	configType := reflect.TypeOf(Config{})
+	keys := make([]string, 0, configType.NumField())
+	for i := 0; i < configType.NumField(); i++ {
+		if key := configFieldKey(configType.Field(i)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// configFieldKey ключ поля Config в JSON, пусто для служебных полей
+func configFieldKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("json"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// configField находит поле Config по ключу
+func configField(config *Config, key string) (reflect.Value, bool) {
+	value := reflect.ValueOf(config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if configFieldKey(value.Type().Field(i)) == key {
+			return value.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// setConfigKey задает параметр из строки: переменной окружения или флага -set.
+// FILTERS передаются в виде JSON
+func setConfigKey(config *Config, key, value string) error {
+	field, ok := configField(config, key)
+	if !ok {
+		return fmt.Errorf("%s: неизвестный параметр", key)
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		number, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s: ожидается число, а не %q", key, value)
+		}
+		field.SetInt(int64(number))
+	default:
+		target := reflect.New(field.Type())
+		if err := json.Unmarshal([]byte(value), target.Interface()); err != nil {
+			return fmt.Errorf("%s: ожидается JSON (%s): %v", key, configTypeName(field.Type()), err)
+		}
+		field.Set(target.Elem())
+	}
+	return nil
+}
+
+// resolveBotToken читает токен из BOT_TOKEN_FILE или из systemd credentials,
+// чтобы он не лежал в config.json и не попадал в окружение процесса
+func (c *Config) resolveBotToken() error {
+	if c.BotTokenFile != "" && c.BotToken != "" {
+		return fmt.Errorf("заданы и BOT_TOKEN, и BOT_TOKEN_FILE, оставь что-то одно")
+	}
+
+	path := c.BotTokenFile
+	if path == "" && c.BotToken == "" {
+		dir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if dir == "" {
+			return nil
+		}
+		path = filepath.Join(dir, botTokenCredential)
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+	}
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("не могу прочитать токен: %w", err)
+	}
+	c.BotToken = strings.TrimSpace(string(data))
+	if c.BotToken == "" {
+		return fmt.Errorf("файл с токеном %s пуст", path)
+	}
+	return nil
+}
+
+// ValidateBot проверяет конфиг для запуска бота: нужны токен и владелец
+func (c *Config) ValidateBot() error {
+	var problems []error
+	if c.BotToken == "" {
+		problems = append(problems, fmt.Errorf("BOT_TOKEN: не задан (в %s, переменной окружения BOT_TOKEN или файлом BOT_TOKEN_FILE)", ConfigFile))
+	}
+	if c.UserID == "" {
+		problems = append(problems, fmt.Errorf("USER_ID: не задан, узнать свой ID можно у @userinfobot"))
+	}
+	if err := c.Validate(); err != nil {
+		problems = append(problems, err)
+	}
+	return errors.Join(problems...)
+}
+
+// Validate проверяет все параметры сразу, чтобы ошибки можно было исправить
+// за один заход, и приводит фильтры к каноническому виду
+func (c *Config) Validate() error {
+	var problems []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Errorf(format, args...))
+		}
+	}
+
+	if c.UserID != "" {
+		_, err := strconv.ParseInt(c.UserID, 10, 64)
+		check(err == nil, "USER_ID: должен быть числовым ID, получено %q", c.UserID)
+	}
+	check(c.NotificationMinutes > 0 && c.NotificationMinutes <= 24*60,
+		"NOTIFICATION_MINUTES: должно быть от 1 до %d, получено %d", 24*60, c.NotificationMinutes)
+	if err := c.validateFilters(); err != nil {
+		problems = append(problems, err)
+	}
+
+	check(c.FacultyID > 0, "FACULTY_ID: должен быть положительным, ID можно узнать командой groups")
+	check(c.Course > 0, "COURSE: должен быть положительным")
+	check(c.GroupID > 0, "GROUP_ID: должен быть положительным, ID можно узнать командой groups")
+	check(c.GroupName != "" && !strings.ContainsAny(c.GroupName, "/?# "),
+		"GROUP_NAME: нужно название группы без пробелов и символов / ? #, получено %q", c.GroupName)
+	_, err := time.LoadLocation(c.Timezone)
+	check(c.Timezone != "" && err == nil, "TIMEZONE: неизвестный часовой пояс %q, пример: Europe/Moscow", c.Timezone)
+	check(c.ScheduleDays > 0 && c.ScheduleDays <= 366,
+		"SCHEDULE_DAYS: должно быть от 1 до 366, получено %d", c.ScheduleDays)
+
+	for _, u := range []struct{ key, value string }{
+		{"TELEGRAM_API_URL", c.TelegramAPIURL},
+		{"TIMETABLE_URL", c.TimetableURL},
+		{"FEED_BASE_URL", c.FeedBaseURL},
+		{"CALDAV_URL", c.CalDAVURL},
+	} {
+		if u.value != "" {
+			parsed, err := url.Parse(u.value)
+			check(err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != "",
+				"%s: ожидается адрес http(s)://..., получено %q", u.key, u.value)
+		}
+	}
+
+	if c.MetricsListen != "" {
+		_, _, err := net.SplitHostPort(c.MetricsListen)
+		check(err == nil, "METRICS_LISTEN: ожидается адрес вида 127.0.0.1:9090, получено %q", c.MetricsListen)
+	}
+
+	if c.AdminChatID != "" {
+		_, err := strconv.ParseInt(c.AdminChatID, 10, 64)
+		check(err == nil, "ADMIN_CHAT_ID: должен быть числовым ID чата, получено %q", c.AdminChatID)
+	}
+	for i, admin := range c.Admins {
+		check(admin > 0, "ADMINS[%d]: должен быть ID пользователя Telegram, получено %d", i, admin)
+	}
+	check(c.StaleAfterHours >= 0, "STALE_AFTER_HOURS: не может быть отрицательным")
+
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		problems = append(problems, fmt.Errorf("LOG_LEVEL: %w", err))
+	}
+	check(slices.Contains(LogFormats, c.LogFormat),
+		"LOG_FORMAT: должен быть одним из %s, получено %q", strings.Join(LogFormats, ", "), c.LogFormat)
+
+	switch c.UpdatesMode {
+	case "", UpdatesModePolling:
+	case UpdatesModeWebhook:
+		webhook := c.Webhook()
+		if err := webhook.Validate(); err != nil {
+			problems = append(problems, fmt.Errorf("ошибка настройки webhook: %w", err))
+		} else {
+			// Сгенерированный секрет должен совпадать в setWebhook и в проверке запросов
+			c.WebhookSecret = webhook.Secret
+		}
+	default:
+		problems = append(problems, fmt.Errorf("UPDATES_MODE: должен быть %q или %q", UpdatesModePolling, UpdatesModeWebhook))
+	}
+
+	return errors.Join(problems...)
+}
+
+// AdminChat возвращает чат для предупреждений: ADMIN_CHAT_ID или владелец из USER_ID
+func (c *Config) AdminChat() int64 {
+	for _, value := range []string{c.AdminChatID, c.UserID} {
+		if chatID, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return chatID
+		}
+	}
+	return 0
+}
+
+// validateFilters проверяет FILTERS, их используют и бот, и сервер календарей
+func (c *Config) validateFilters() error {
+	for i, filter := range c.Filters {
+		parsed, err := ParseLessonFilter(filter.Field, filter.Value)
+		if err != nil {
+			return fmt.Errorf("FILTERS[%d]: %w", i, err)
+		}
+		c.Filters[i] = parsed
+	}
+	return nil
+}
+
+// Location возвращает часовой пояс расписания из TIMEZONE
+func (c *Config) Location() *time.Location {
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		// Validate уже отклонил неверный TIMEZONE, сюда попадаем только без проверки
+		return defaultLocation()
+	}
+	return loc
+}
+
+// ParserConfig собирает настройки парсера: группа, часовой пояс и диапазон дат
+func (c *Config) ParserConfig() parser.ParserConfig {
+	return parser.ParserConfig{
+		FacultyID: c.FacultyID,
+		Course:    c.Course,
+		GroupID:   c.GroupID,
+		GroupName: c.GroupName,
+		Days:      c.ScheduleDays,
+		BaseURL:   c.TimetableURL,
+		Location:  c.Location(),
+	}
+}
+
+// Webhook собирает настройки webhook из конфига
+func (c *Config) Webhook() WebhookSettings {
+	return WebhookSettings{
+		URL:      c.WebhookURL,
+		Listen:   c.WebhookListen,
+		Secret:   c.WebhookSecret,
+		CertFile: c.WebhookCert,
+		KeyFile:  c.WebhookKey,
+	}
+}
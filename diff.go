@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"msuparser/parser"
+)
+
+// LessonChange пара, у которой поменялись преподаватель, аудитория или время конца
+type LessonChange struct {
+	Old parser.Lesson
+	New parser.Lesson
+}
+
+// ScheduleDiff разница между двумя выгрузками расписания.
+// Пары сопоставляются по Lesson.ID (группа, дата, начало, предмет)
+type ScheduleDiff struct {
+	Added   []parser.Lesson
+	Removed []parser.Lesson
+	Changed []LessonChange
+}
+
+// Empty проверяет, что расписания совпадают
+func (d ScheduleDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffSchedules сравнивает старое и новое расписание
+func DiffSchedules(old, new []parser.Lesson) ScheduleDiff {
+	var diff ScheduleDiff
+
+	oldByID := make(map[string]parser.Lesson, len(old))
+	for _, lesson := range old {
+		oldByID[lesson.ID()] = lesson
+	}
+
+	seen := make(map[string]bool, len(new))
+	for _, lesson := range new {
+		id := lesson.ID()
+		seen[id] = true
+
+		previous, ok := oldByID[id]
+		if !ok {
+			diff.Added = append(diff.Added, lesson)
+			continue
+		}
+		if previous != lesson {
+			diff.Changed = append(diff.Changed, LessonChange{Old: previous, New: lesson})
+		}
+	}
+
+	for _, lesson := range old {
+		if !seen[lesson.ID()] {
+			diff.Removed = append(diff.Removed, lesson)
+		}
+	}
+
+	sortLessons(diff.Added)
+	sortLessons(diff.Removed)
+	sort.SliceStable(diff.Changed, func(i, j int) bool {
+		return lessonKey(&diff.Changed[i].New) < lessonKey(&diff.Changed[j].New)
+	})
+
+	return diff
+}
+
+// WriteDiff выводит разницу в читаемом виде
+func WriteDiff(w io.Writer, diff ScheduleDiff) error {
+	var b strings.Builder
+
+	if diff.Empty() {
+		b.WriteString("Расписание не изменилось\n")
+	}
+	for _, lesson := range diff.Added {
+		fmt.Fprintf(&b, "+ %s\n", describeLesson(&lesson))
+	}
+	for _, lesson := range diff.Removed {
+		fmt.Fprintf(&b, "- %s\n", describeLesson(&lesson))
+	}
+	for _, change := range diff.Changed {
+		fmt.Fprintf(&b, "~ %s\n", describeLesson(&change.New))
+		for _, field := range changedFields(&change.Old, &change.New) {
+			fmt.Fprintf(&b, "    %s\n", field)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// changedFields перечисляет изменившиеся поля в виде "поле: было -> стало"
+func changedFields(old, new *parser.Lesson) []string {
+	var fields []string
+	add := func(name, before, after string) {
+		if before != after {
+			fields = append(fields, fmt.Sprintf("%s: %q -> %q", name, before, after))
+		}
+	}
+	add("конец", old.TimeEnd, new.TimeEnd)
+	add("номер пары", old.LessonNumber, new.LessonNumber)
+	add("преподаватель", old.Teacher, new.Teacher)
+	add("аудитория", old.Room, new.Room)
+	add("день недели", old.Weekday, new.Weekday)
+	return fields
+}
+
+func describeLesson(lesson *parser.Lesson) string {
+	text := fmt.Sprintf("%s %s-%s %s", lesson.Date, lesson.TimeStart, lesson.TimeEnd, lesson.Subject)
+	if lesson.Room != "" {
+		text += " (" + lesson.Room + ")"
+	}
+	return text
+}
+
+// sortLessons упорядочивает пары по дате и времени начала
+func sortLessons(lessons []parser.Lesson) {
+	sort.SliceStable(lessons, func(i, j int) bool {
+		return lessonKey(&lessons[i]) < lessonKey(&lessons[j])
+	})
+}
+
+func lessonKey(lesson *parser.Lesson) string {
+	return dateKey(lesson.Date) + " " + lesson.TimeStart
+}
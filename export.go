@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"msuparser/parser"
+)
+
+// Форматы вывода расписания
+const (
+	FormatJSON     = "json"
+	FormatJSONL    = "jsonl"
+	FormatCSV      = "csv"
+	FormatMarkdown = "md"
+	FormatICS      = "ics"
+	FormatText     = "text"
+)
+
+// OutputFormats список поддерживаемых форматов для справки
+var OutputFormats = []string{FormatJSON, FormatJSONL, FormatCSV, FormatMarkdown, FormatICS, FormatText}
+
+// FormatFromPath угадывает формат по расширению файла, по умолчанию json
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".ndjson":
+		return FormatJSONL
+	case ".csv":
+		return FormatCSV
+	case ".md", ".markdown":
+		return FormatMarkdown
+	case ".ics", ".ical":
+		return FormatICS
+	case ".txt":
+		return FormatText
+	}
+	return FormatJSON
+}
+
+// WriteSchedule записывает расписание в выбранном формате; loc нужен для
+// iCalendar. JSON пишется в формате schedule.json, вместе с метаданными
+// выгрузки, остальные форматы содержат только пары
+func WriteSchedule(w io.Writer, format string, schedule *parser.Schedule, loc *time.Location) error {
+	lessons := schedule.Lessons
+	switch format {
+	case FormatJSON:
+		return writeJSON(w, schedule)
+	case FormatJSONL:
+		return writeJSONL(w, lessons)
+	case FormatCSV:
+		return writeCSV(w, lessons)
+	case FormatMarkdown:
+		return writeMarkdown(w, lessons)
+	case FormatICS:
+		return WriteICS(w, lessons, ICSOptions{Name: "Расписание МГУ ВШГА", Stamp: time.Now(), Location: loc})
+	case FormatText:
+		return writeText(w, lessons)
+	}
+	return fmt.Errorf("неизвестный формат %q, доступны: %s", format, strings.Join(OutputFormats, ", "))
+}
+
+// ReadSchedule читает schedule.json в новом или старом (голый массив) формате.
+// Для старого формата время выгрузки берется из времени изменения файла
+func ReadSchedule(path string) (*parser.Schedule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := parser.UnmarshalSchedule(data)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка парсинга %s: %w", path, err)
+	}
+	if schedule.FetchedAt.IsZero() {
+		schedule.FetchedAt = fileModTime(path)
+	}
+	return schedule, nil
+}
+
+// ReadLessons читает только пары из файла расписания
+func ReadLessons(path string) ([]parser.Lesson, error) {
+	schedule, err := ReadSchedule(path)
+	if err != nil {
+		return nil, err
+	}
+	return schedule.Lessons, nil
+}
+
+// SaveSchedule атомарно сохраняет расписание в формате schedule.json
+func SaveSchedule(path string, schedule *parser.Schedule) error {
+	data, err := parser.MarshalSchedule(schedule)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// writeFileAtomic записывает файл через временный файл и rename, поэтому
+// при сбое посреди записи на диске остается старая версия, а не обрывок
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// После успешного rename удалять уже нечего, ошибка игнорируется
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// FilterByDate оставляет пары с from по to включительно (формат 02.01.2006).
+// Пустая граница не ограничивает
+func FilterByDate(lessons []parser.Lesson, from, to string) ([]parser.Lesson, error) {
+	var fromDate, toDate time.Time
+	var err error
+	if from != "" {
+		if fromDate, err = time.Parse("02.01.2006", from); err != nil {
+			return nil, fmt.Errorf("неверная дата %q, нужен формат ДД.ММ.ГГГГ", from)
+		}
+	}
+	if to != "" {
+		if toDate, err = time.Parse("02.01.2006", to); err != nil {
+			return nil, fmt.Errorf("неверная дата %q, нужен формат ДД.ММ.ГГГГ", to)
+		}
+	}
+
+	filtered := []parser.Lesson{}
+	for _, lesson := range lessons {
+		date, err := time.Parse("02.01.2006", lesson.Date)
+		if err != nil {
+			continue
+		}
+		if !fromDate.IsZero() && date.Before(fromDate) {
+			continue
+		}
+		if !toDate.IsZero() && date.After(toDate) {
+			continue
+		}
+		filtered = append(filtered, lesson)
+	}
+	return filtered, nil
+}
+
+func writeJSON(w io.Writer, schedule *parser.Schedule) error {
+	// Выгрузка из старого формата (голый массив) пишется уже в текущем
+	current := *schedule
+	current.SchemaVersion = parser.ScheduleSchemaVersion
+	data, err := parser.MarshalSchedule(&current)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func writeJSONL(w io.Writer, lessons []parser.Lesson) error {
+	encoder := json.NewEncoder(w)
+	for _, lesson := range lessons {
+		if err := encoder.Encode(lesson); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, lessons []parser.Lesson) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"date", "weekday", "lesson_number", "time_start", "time_end", "subject", "type", "teacher", "room", "group"})
+	for _, lesson := range lessons {
+		out.Write([]string{
+			lesson.Date,
+			lesson.Weekday,
+			lesson.LessonNumber,
+			lesson.TimeStart,
+			lesson.TimeEnd,
+			lesson.SubjectName(),
+			lesson.LessonType(),
+			lesson.Teacher,
+			lesson.Room,
+			lesson.Group,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func writeMarkdown(w io.Writer, lessons []parser.Lesson) error {
+	cell := strings.NewReplacer("|", `\|`, "\n", " ").Replace
+
+	var b strings.Builder
+	b.WriteString("| Дата | День | Пара | Время | Предмет | Тип | Преподаватель | Аудитория |\n")
+	b.WriteString("|------|------|------|-------|---------|-----|---------------|-----------|\n")
+	for _, lesson := range lessons {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s-%s | %s | %s | %s | %s |\n",
+			lesson.Date,
+			lesson.Weekday,
+			lesson.LessonNumber,
+			lesson.TimeStart,
+			lesson.TimeEnd,
+			cell(lesson.SubjectName()),
+			cell(lesson.LessonType()),
+			cell(lesson.Teacher),
+			cell(lesson.Room),
+		)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeText выводит расписание в читаемом виде, по дням
+func writeText(w io.Writer, lessons []parser.Lesson) error {
+	var b strings.Builder
+	currentDate := ""
+	for i, lesson := range lessons {
+		// Печатаем заголовок даты
+		if lesson.Date != currentDate {
+			currentDate = lesson.Date
+			fmt.Fprintf(&b, "\n📅 %s (%s)\n", lesson.Date, lesson.Weekday)
+			b.WriteString(strings.Repeat("=", 50) + "\n")
+		}
+
+		fmt.Fprintf(&b, "%d. %s пара (%s - %s)\n", i+1, lesson.LessonNumber, lesson.TimeStart, lesson.TimeEnd)
+		fmt.Fprintf(&b, "   📚 %s\n", lesson.Subject)
+		if lesson.Teacher != "" {
+			fmt.Fprintf(&b, "   👨‍🏫 %s\n", lesson.Teacher)
+		}
+		if lesson.Room != "" {
+			fmt.Fprintf(&b, "   🚪 %s\n", lesson.Room)
+		}
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
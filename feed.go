@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"msuparser/parser"
+)
+
+const feedCacheControl = "max-age=900"
+
+// FeedHandler отдает расписание в формате iCalendar:
+//
+//	/calendar/303.ics         - вся группа, с общими фильтрами из конфига
+//	/calendar/u/<token>.ics   - личная ссылка подписчика, с его фильтрами
+func (bot *TimetableBot) FeedHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/calendar/")
+		if !strings.HasSuffix(name, ".ics") {
+			http.NotFound(w, r)
+			return
+		}
+		name = strings.TrimSuffix(name, ".ics")
+
+		if token := strings.TrimPrefix(name, "u/"); token != name {
+			settings, ok := bot.subscribers.FindByFeedToken(token)
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			bot.serveFeed(w, r, settings.VisibleLessons(bot.Schedule(), bot.runtime().config.Filters), "Мое расписание МГУ ВШГА")
+			return
+		}
+
+		var lessons []parser.Lesson
+		for _, lesson := range bot.Schedule() {
+			if lesson.Group == name {
+				lessons = append(lessons, lesson)
+			}
+		}
+		if len(lessons) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+
+		// Пустые настройки чата - действуют только общие фильтры из конфига
+		bot.serveFeed(w, r, ChatSettings{}.VisibleLessons(lessons, bot.runtime().config.Filters), "Расписание МГУ ВШГА, группа "+name)
+	})
+}
+
+// serveFeed генерирует календарь и поддерживает условные запросы,
+// чтобы календарные клиенты могли дешево проверять обновления
+func (bot *TimetableBot) serveFeed(w http.ResponseWriter, r *http.Request, lessons []parser.Lesson, name string) {
+	modified := bot.ScheduleModTime().UTC().Truncate(time.Second)
+
+	var body bytes.Buffer
+	if err := WriteICS(&body, lessons, ICSOptions{Name: name, Stamp: modified, Location: bot.runtime().location}); err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", feedCacheControl)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	}
+
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", body.Len()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body.Bytes())
+}
+
+// notModified проверяет If-None-Match и If-Modified-Since (RFC 7232)
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		// If-Modified-Since игнорируется, если есть If-None-Match
+		return false
+	}
+
+	if since := r.Header.Get("If-Modified-Since"); since != "" && !modified.IsZero() {
+		t, err := http.ParseTime(since)
+		return err == nil && !modified.After(t)
+	}
+	return false
+}
+
+// StartHTTPServer запускает HTTP сервер с календарями, он работает до отмены ctx.
+// Метрики и проверки состояния попадают на него, только если METRICS_LISTEN
+// указывает на тот же адрес
+func (bot *TimetableBot) StartHTTPServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("/calendar/", bot.FeedHandler())
+	if bot.metricsListen == bot.httpListen {
+		bot.handleMonitoring(mux)
+	}
+	bot.startServer(ctx, "HTTP сервер", bot.httpListen, mux)
+}
+
+// startServer обслуживает mux на адресе listen в фоне до отмены ctx
+func (bot *TimetableBot) startServer(ctx context.Context, name, listen string, mux *http.ServeMux) {
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	slog.Info("🌐 "+name+" запущен", "listen", listen)
+	bot.goBackground(func() {
+		if err := serveHTTP(ctx, server, server.ListenAndServe); err != nil {
+			slog.Error("❌ "+name+" остановлен", "listen", listen, "err", err)
+		}
+	})
+}
+
+// feedURL возвращает адрес личного календаря подписчика
+func (bot *TimetableBot) feedURL(token string) string {
+	return strings.TrimRight(bot.runtime().config.FeedBaseURL, "/") + "/calendar/u/" + token + ".ics"
+}
+
+// newFeedToken генерирует секретный токен для личной ссылки на календарь
+func newFeedToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
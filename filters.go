@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"msuparser/parser"
+)
+
+// Поля пары, по которым можно фильтровать
+const (
+	FilterSubject  = "subject"
+	FilterType     = "type"
+	FilterTeacher  = "teacher"
+	FilterSubgroup = "subgroup"
+)
+
+// filterFieldNames - названия полей для сообщений бота
+var filterFieldNames = map[string]string{
+	FilterSubject:  "предмет",
+	FilterType:     "тип",
+	FilterTeacher:  "преподаватель",
+	FilterSubgroup: "подгруппа",
+}
+
+// Отметка подгруппы в названии пары: "(1 подгр.)", "2 подгруппа", "подгруппа 1", "п/г 2"
+var subgroupPattern = regexp.MustCompile(`(?i)(?:(\d+)\s*(?:-?я\s*)?(?:подгр[а-яё]*\.?|п/г))|(?:(?:подгр[а-яё]*\.?|п/г)\s*№?\s*(\d+))`)
+
+// lessonSubgroup возвращает номер подгруппы из текста или "", если отметки нет
+func lessonSubgroup(text string) string {
+	match := subgroupPattern.FindStringSubmatch(text)
+	if match == nil {
+		return ""
+	}
+	if match[1] != "" {
+		return match[1]
+	}
+	return match[2]
+}
+
+// LessonFilter скрывает пары, у которых поле Field содержит Value (без учета
+// регистра). Подгруппа сравнивается по номеру из отметки в названии пары
+type LessonFilter struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+// ParseLessonFilter создает фильтр из названия поля и значения
+func ParseLessonFilter(field, value string) (LessonFilter, error) {
+	field = strings.ToLower(strings.TrimSpace(field))
+	value = strings.TrimSpace(value)
+
+	// Разрешаем писать поле по-русски
+	for key, name := range filterFieldNames {
+		if field == name {
+			field = key
+		}
+	}
+
+	if _, ok := filterFieldNames[field]; !ok {
+		return LessonFilter{}, fmt.Errorf("неизвестное поле %q, доступны: subject, type, teacher, subgroup", field)
+	}
+	if value == "" {
+		return LessonFilter{}, fmt.Errorf("пустое значение фильтра")
+	}
+
+	// Подгруппа хранится номером: "1", "2 подгр" и "подгруппа 2" значат одно и то же
+	if field == FilterSubgroup {
+		number := value
+		if !isDigits(number) {
+			number = lessonSubgroup(value)
+		}
+		if number == "" {
+			return LessonFilter{}, fmt.Errorf("нужен номер подгруппы, например: subgroup 2")
+		}
+		value = number
+	}
+
+	return LessonFilter{Field: field, Value: value}, nil
+}
+
+// Matches проверяет подходит ли пара под фильтр
+func (f LessonFilter) Matches(lesson *parser.Lesson) bool {
+	var text string
+	switch f.Field {
+	case FilterSubject:
+		text = lesson.SubjectName()
+	case FilterType:
+		text = lesson.LessonType()
+	case FilterTeacher:
+		text = lesson.Teacher
+	case FilterSubgroup:
+		// Отметка подгруппы стоит в названии: "Английский язык (1 подгр.)".
+		// Сравниваем номера целиком, чтобы "1" не скрывала "Право 1С"
+		subgroup := lessonSubgroup(lesson.Subject)
+		return subgroup != "" && strings.TrimLeft(subgroup, "0") == strings.TrimLeft(f.Value, "0")
+	default:
+		return false
+	}
+
+	if text == "" {
+		return false
+	}
+	return strings.Contains(strings.ToLower(text), strings.ToLower(f.Value))
+}
+
+// isDigits проверяет, что строка состоит только из цифр
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func (f LessonFilter) String() string {
+	return fmt.Sprintf("%s: %s", filterFieldNames[f.Field], f.Value)
+}
+
+// Hides проверяет скрыта ли пара общими фильтрами из конфига или фильтрами чата
+func (s ChatSettings) Hides(lesson *parser.Lesson, global []LessonFilter) bool {
+	for _, filter := range global {
+		if filter.Matches(lesson) {
+			return true
+		}
+	}
+	for _, filter := range s.Filters {
+		if filter.Matches(lesson) {
+			return true
+		}
+	}
+	return false
+}
+
+// VisibleLessons возвращает пары, не скрытые общими фильтрами и фильтрами чата
+func (s ChatSettings) VisibleLessons(lessons []parser.Lesson, global []LessonFilter) []parser.Lesson {
+	visible := []parser.Lesson{}
+	for _, lesson := range lessons {
+		if !s.Hides(&lesson, global) {
+			visible = append(visible, lesson)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"msuparser/parser"
+)
+
+func TestSubgroupFilter(t *testing.T) {
+	tests := []struct {
+		value   string
+		subject string
+		hidden  bool
+	}{
+		{"1", "Английский язык (1 подгр.) [Семинар]", true},
+		{"1", "Английский язык (2 подгр.) [Семинар]", false},
+		{"2 подгр", "Английский язык (2 подгр.) [Семинар]", true},
+		{"подгруппа 2", "Немецкий язык, 2 подгруппа", true},
+		{"2", "Физкультура п/г 2", true},
+		{"1", "Право 1С [Лекция]", false},
+		{"1", "Английский язык (11 подгр.)", false},
+		{"1", "Английский язык [Семинар]", false},
+	}
+
+	for _, tt := range tests {
+		filter, err := ParseLessonFilter("subgroup", tt.value)
+		if err != nil {
+			t.Fatalf("ParseLessonFilter(%q): %v", tt.value, err)
+		}
+		lesson := parser.Lesson{Subject: tt.subject}
+		if got := filter.Matches(&lesson); got != tt.hidden {
+			t.Errorf("фильтр %q, пара %q: скрыта = %v, ожидалось %v", tt.value, tt.subject, got, tt.hidden)
+		}
+	}
+}
+
+func TestSubgroupFilterNeedsNumber(t *testing.T) {
+	if _, err := ParseLessonFilter("подгруппа", "первая"); err == nil {
+		t.Fatal("фильтр подгруппы без номера должен быть ошибкой")
+	}
+}
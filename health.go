@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"msuparser/parser"
+)
+
+// Планировщик отмечается каждую минуту; обновление расписания может
+// задержать отметку, поэтому запас большой
+const heartbeatTimeout = 5 * time.Minute
+
+// Подсистемы, последние ошибки которых показывает /status, в порядке
+// вывода. Ошибки Telegram хранит TelegramClient
+const (
+	SubsystemFetch    = "fetch"
+	SubsystemTelegram = "telegram"
+	SubsystemCalDAV   = "caldav"
+	SubsystemReload   = "reload"
+)
+
+var subsystems = []string{SubsystemFetch, SubsystemTelegram, SubsystemCalDAV, SubsystemReload}
+
+var subsystemNames = map[string]string{
+	SubsystemFetch:    "обновление расписания",
+	SubsystemTelegram: "Telegram",
+	SubsystemCalDAV:   "CalDAV",
+	SubsystemReload:   "перечитывание файлов",
+}
+
+// subsystemError последняя ошибка подсистемы
+type subsystemError struct {
+	At  time.Time
+	Err string
+}
+
+// subsystemErrors последние ошибки подсистем для /status
+type subsystemErrors struct {
+	mu   sync.Mutex
+	last map[string]subsystemError
+}
+
+func newSubsystemErrors() *subsystemErrors {
+	return &subsystemErrors{last: make(map[string]subsystemError)}
+}
+
+// Record запоминает ошибку подсистемы, nil игнорируется
+func (e *subsystemErrors) Record(subsystem string, err error) {
+	if err == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last[subsystem] = subsystemError{At: time.Now(), Err: err.Error()}
+}
+
+// All возвращает копию последних ошибок
+func (e *subsystemErrors) All() map[string]subsystemError {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	all := make(map[string]subsystemError, len(e.last))
+	for subsystem, last := range e.last {
+		all[subsystem] = last
+	}
+	return all
+}
+
+// beat отмечает, что цикл планировщика жив
+func (bot *TimetableBot) beat() {
+	bot.heartbeat.Store(time.Now().UnixNano())
+}
+
+// healthCheck одна проверка готовности
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// readiness проверяет, что бот может делать свою работу: расписание
+// загружено и не устарело, Telegram отвечает (если бот с ним работает)
+func (bot *TimetableBot) readiness() []healthCheck {
+	lessons := len(bot.Schedule())
+	checks := []healthCheck{{
+		Name:   "schedule",
+		OK:     lessons > 0,
+		Detail: fmt.Sprintf("пар в расписании: %d", lessons),
+	}}
+
+	fetch := healthCheck{Name: "fetch"}
+	if fetchedAt := bot.ScheduleModTime(); fetchedAt.IsZero() {
+		fetch.Detail = "время получения расписания неизвестно"
+	} else {
+		age := bot.now().Sub(fetchedAt)
+		staleAfter := bot.fetchHealth.Status().StaleAfter
+		fetch.OK = age <= staleAfter
+		fetch.Detail = fmt.Sprintf("получено %s назад, допустимо %s", formatAge(age), formatAge(staleAfter))
+	}
+	checks = append(checks, fetch)
+
+	if bot.telegram != nil {
+		health := bot.telegram.Health()
+		telegram := healthCheck{Name: "telegram", OK: health.Reachable}
+		switch {
+		case health.Reachable:
+			telegram.Detail = "доступен"
+		case health.LastError != nil:
+			telegram.Detail = health.LastError.Error()
+		default:
+			telegram.Detail = "запросов еще не было"
+		}
+		checks = append(checks, telegram)
+	}
+	return checks
+}
+
+// HealthzHandler отвечает 200, пока цикл планировщика жив (liveness)
+func (bot *TimetableBot) HealthzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		last := bot.heartbeat.Load()
+		if last == 0 || time.Since(time.Unix(0, last)) > heartbeatTimeout {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "scheduler stalled")
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// ReadyzHandler отвечает 200, если все проверки readiness прошли, иначе 503.
+// В теле - результаты проверок в JSON
+func (bot *TimetableBot) ReadyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks := bot.readiness()
+		ready := true
+		for _, check := range checks {
+			ready = ready && check.OK
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(struct {
+			Ready  bool          `json:"ready"`
+			Checks []healthCheck `json:"checks"`
+		}{ready, checks})
+	})
+}
+
+// plannedNotification напоминание, которое планировщик отправит, если
+// расписание не изменится
+type plannedNotification struct {
+	At     time.Time
+	Lesson parser.Lesson
+	Chats  int
+}
+
+// upcomingNotifications возвращает limit ближайших напоминаний по всем
+// подписчикам с теми же правилами, что и CheckAndSendNotifications
+func (bot *TimetableBot) upcomingNotifications(limit int) []plannedNotification {
+	loc := bot.runtime().location
+	byKey := make(map[string]*plannedNotification)
+
+	for _, chat := range bot.subscribers.All() {
+		for _, lesson := range bot.GetUpcomingLessons(chat) {
+			if isDistanceLearning(lesson.Room) && !chat.DistanceReminders && !bot.HasInPersonLessons(chat, lesson.Date) {
+				continue
+			}
+			if chat.IsQuiet(lesson.Notification.In(loc)) {
+				continue
+			}
+
+			key := fmt.Sprintf("%s_%d", lesson.ID(), lesson.Notification.Unix())
+			if planned, ok := byKey[key]; ok {
+				planned.Chats++
+				continue
+			}
+			byKey[key] = &plannedNotification{At: lesson.Notification, Lesson: lesson, Chats: 1}
+		}
+	}
+
+	planned := make([]plannedNotification, 0, len(byKey))
+	for _, p := range byKey {
+		planned = append(planned, *p)
+	}
+	sort.Slice(planned, func(i, j int) bool {
+		if !planned[i].At.Equal(planned[j].At) {
+			return planned[i].At.Before(planned[j].At)
+		}
+		return planned[i].Lesson.Subject < planned[j].Lesson.Subject
+	})
+	if len(planned) > limit {
+		planned = planned[:limit]
+	}
+	return planned
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"msuparser/parser"
+)
+
+const DefaultHistoryDir = "history"
+
+// HistoryEntry запись журнала истории: когда расписание впервые стало таким
+type HistoryEntry struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	Hash      string    `json:"hash"`
+	Lessons   int       `json:"lessons"`
+	DateStart string    `json:"date_start,omitempty"`
+	DateEnd   string    `json:"date_end,omitempty"`
+}
+
+// ShortHash короткий идентификатор снимка для команд
+func (e HistoryEntry) ShortHash() string {
+	if len(e.Hash) < 12 {
+		return e.Hash
+	}
+	return e.Hash[:12]
+}
+
+// History архив выгрузок расписания. Содержимое хранится по хэшу пар
+// (<dir>/<hash>.json), поэтому одинаковые выгрузки занимают место один раз.
+// Журнал <dir>/index.jsonl получает строку только когда расписание
+// изменилось, так что по нему видно, каким оно было на любую дату
+type History struct {
+	dir string
+	loc *time.Location // В нем понимаются даты в Find
+	mu  sync.Mutex
+}
+
+// NewHistory создает архив в каталоге dir; loc - часовой пояс расписания
+func NewHistory(dir string, loc *time.Location) *History {
+	return &History{dir: dir, loc: loc}
+}
+
+// Add сохраняет выгрузку, если она отличается от последней в архиве
+func (h *History) Add(schedule *parser.Schedule) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hash, err := scheduleHash(schedule.Lessons)
+	if err != nil {
+		return false, err
+	}
+
+	entries, err := h.entries()
+	if err != nil {
+		return false, err
+	}
+	if len(entries) > 0 && entries[len(entries)-1].Hash == hash {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(h.dir, 0755); err != nil {
+		return false, err
+	}
+
+	objectFile := h.objectFile(hash)
+	if _, err := os.Stat(objectFile); os.IsNotExist(err) {
+		if err := SaveSchedule(objectFile, schedule); err != nil {
+			return false, err
+		}
+	}
+
+	entry := HistoryEntry{
+		FetchedAt: schedule.FetchedAt,
+		Hash:      hash,
+		Lessons:   len(schedule.Lessons),
+		DateStart: schedule.DateStart,
+		DateEnd:   schedule.DateEnd,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+
+	file, err := os.OpenFile(h.indexFile(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Entries возвращает журнал от старых снимков к новым
+func (h *History) Entries() ([]HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.entries()
+}
+
+func (h *History) entries() ([]HistoryEntry, error) {
+	file, err := os.Open(h.indexFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			// Оборванная последняя строка после сбоя не должна ломать весь архив
+			slog.Warn("⚠️ Пропускаю поврежденную строку архива", "file", h.indexFile(), "err", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Find ищет снимок по номеру из history list, префиксу хэша или дате
+// ДД.ММ.ГГГГ (последний снимок, полученный не позже конца этого дня)
+func (h *History) Find(ref string) (HistoryEntry, error) {
+	entries, err := h.Entries()
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+	if len(entries) == 0 {
+		return HistoryEntry{}, fmt.Errorf("архив %s пуст", h.dir)
+	}
+
+	if ref == "latest" {
+		return entries[len(entries)-1], nil
+	}
+
+	if date, err := time.ParseInLocation("02.01.2006", ref, h.loc); err == nil {
+		end := date.AddDate(0, 0, 1)
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].FetchedAt.Before(end) {
+				return entries[i], nil
+			}
+		}
+		return HistoryEntry{}, fmt.Errorf("на %s снимков еще нет, первый от %s", ref, entries[0].FetchedAt.In(h.loc).Format("02.01.2006"))
+	}
+
+	// Короткое число - номер из list, длинное или с ведущим нулем - начало хэша
+	if n, err := strconv.Atoi(ref); err == nil && len(ref) <= 4 && ref[0] != '0' {
+		if n < 1 || n > len(entries) {
+			return HistoryEntry{}, fmt.Errorf("нет снимка №%d, всего %d", n, len(entries))
+		}
+		return entries[n-1], nil
+	}
+
+	var found []HistoryEntry
+	seen := make(map[string]bool)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(entries[i].Hash, strings.ToLower(ref)) && !seen[entries[i].Hash] {
+			seen[entries[i].Hash] = true
+			found = append(found, entries[i])
+		}
+	}
+	switch len(found) {
+	case 0:
+		return HistoryEntry{}, fmt.Errorf("снимок %q не найден", ref)
+	case 1:
+		return found[0], nil
+	}
+	return HistoryEntry{}, fmt.Errorf("префикс %q подходит к нескольким снимкам, укажите длиннее", ref)
+}
+
+// Load читает расписание снимка
+func (h *History) Load(entry HistoryEntry) (*parser.Schedule, error) {
+	schedule, err := ReadSchedule(h.objectFile(entry.Hash))
+	if err != nil {
+		return nil, err
+	}
+	// Содержимое могло быть сохранено при более ранней выгрузке
+	schedule.FetchedAt = entry.FetchedAt
+	schedule.DateStart = entry.DateStart
+	schedule.DateEnd = entry.DateEnd
+	return schedule, nil
+}
+
+func (h *History) indexFile() string {
+	return filepath.Join(h.dir, "index.jsonl")
+}
+
+func (h *History) objectFile(hash string) string {
+	return filepath.Join(h.dir, hash+".json")
+}
+
+// scheduleHash хэш пар без метаданных выгрузки: одинаковое расписание,
+// полученное в разные дни, дает один и тот же хэш
+func scheduleHash(lessons []parser.Lesson) (string, error) {
+	data, err := json.Marshal(lessons)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"msuparser/parser"
+)
+
+// Москва живет в UTC+3 без перехода на летнее время с 2014 года
+const icsMoscowTimezone = "BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Moscow\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:19700101T000000\r\n" +
+	"TZOFFSETFROM:+0300\r\n" +
+	"TZOFFSETTO:+0300\r\n" +
+	"TZNAME:MSK\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n"
+
+var linkPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// ICSOptions параметры экспорта в iCalendar
+type ICSOptions struct {
+	Name     string         // Название календаря (X-WR-CALNAME)
+	Stamp    time.Time      // DTSTAMP событий, обычно время получения расписания
+	Location *time.Location // Часовой пояс расписания, nil - Europe/Moscow
+}
+
+// location часовой пояс, в котором записаны даты и время пар
+func (opts ICSOptions) location() *time.Location {
+	if opts.Location == nil {
+		return defaultLocation()
+	}
+	return opts.Location
+}
+
+// icsZone возвращает TZID и описание VTIMEZONE. Описание есть только для
+// Москвы, для других поясов время пар пишется в UTC, и TZID пустой
+func icsZone(loc *time.Location) (tzid, vtimezone string) {
+	if loc.String() == DefaultTimezone {
+		return DefaultTimezone, icsMoscowTimezone
+	}
+	return "", ""
+}
+
+// WriteICS записывает расписание в формате iCalendar (RFC 5545)
+func WriteICS(w io.Writer, lessons []parser.Lesson, opts ICSOptions) error {
+	out := bufio.NewWriter(w)
+
+	writeICSHeader(out)
+	writeICSLine(out, "METHOD:PUBLISH")
+	if opts.Name != "" {
+		writeICSLine(out, "X-WR-CALNAME:"+escapeICSText(opts.Name))
+	}
+	writeICSLine(out, "X-WR-TIMEZONE:"+opts.location().String())
+	_, vtimezone := icsZone(opts.location())
+	out.WriteString(vtimezone)
+
+	for _, lesson := range lessons {
+		if err := writeICSEvent(out, &lesson, opts); err != nil {
+			// Пару с битой датой пропускаем, остальные экспортируем
+			continue
+		}
+	}
+
+	writeICSLine(out, "END:VCALENDAR")
+	return out.Flush()
+}
+
+// WriteICSResource записывает одну пару как отдельный календарный объект
+// для CalDAV: без METHOD, как требует RFC 4791 (4.1)
+func WriteICSResource(w io.Writer, lesson *parser.Lesson, opts ICSOptions) error {
+	out := bufio.NewWriter(w)
+
+	writeICSHeader(out)
+	_, vtimezone := icsZone(opts.location())
+	out.WriteString(vtimezone)
+	if err := writeICSEvent(out, lesson, opts); err != nil {
+		return err
+	}
+	writeICSLine(out, "END:VCALENDAR")
+	return out.Flush()
+}
+
+func writeICSHeader(out *bufio.Writer) {
+	writeICSLine(out, "BEGIN:VCALENDAR")
+	writeICSLine(out, "VERSION:2.0")
+	writeICSLine(out, "PRODID:-//msuparser//MSU Timetable//RU")
+	writeICSLine(out, "CALSCALE:GREGORIAN")
+}
+
+// writeICSEvent записывает одну пару как VEVENT
+func writeICSEvent(out *bufio.Writer, lesson *parser.Lesson, opts ICSOptions) error {
+	loc := opts.location()
+	start, err := ParseTime(lesson.Date, lesson.TimeStart, loc)
+	if err != nil {
+		return err
+	}
+	end, err := ParseTime(lesson.Date, lesson.TimeEnd, loc)
+	if err != nil {
+		return err
+	}
+	stamp := opts.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+
+	writeICSLine(out, "BEGIN:VEVENT")
+	writeICSLine(out, "UID:"+lesson.ID()+"@msuparser")
+	writeICSLine(out, "DTSTAMP:"+stamp.UTC().Format("20060102T150405Z"))
+	if tzid, _ := icsZone(loc); tzid != "" {
+		writeICSLine(out, "DTSTART;TZID="+tzid+":"+start.Format("20060102T150405"))
+		writeICSLine(out, "DTEND;TZID="+tzid+":"+end.Format("20060102T150405"))
+	} else {
+		writeICSLine(out, "DTSTART:"+start.UTC().Format("20060102T150405Z"))
+		writeICSLine(out, "DTEND:"+end.UTC().Format("20060102T150405Z"))
+	}
+	writeICSLine(out, "SUMMARY:"+escapeICSText(lesson.Subject))
+	if lesson.Room != "" {
+		writeICSLine(out, "LOCATION:"+escapeICSText(lesson.Room))
+	}
+	writeICSLine(out, "DESCRIPTION:"+escapeICSText(icsDescription(lesson)))
+
+	links := lessonLinks(lesson)
+	if len(links) > 0 {
+		writeICSLine(out, "URL:"+links[0])
+	}
+	writeICSLine(out, "END:VEVENT")
+	return nil
+}
+
+// icsDescription собирает описание события: преподаватель, тип, ссылки
+func icsDescription(lesson *parser.Lesson) string {
+	var lines []string
+	if lesson.Teacher != "" {
+		lines = append(lines, "Преподаватель: "+lesson.Teacher)
+	}
+	if lessonType := lesson.LessonType(); lessonType != "" {
+		lines = append(lines, "Тип: "+lessonType)
+	}
+	lines = append(lines, fmt.Sprintf("%s пара, группа %s", lesson.LessonNumber, lesson.Group))
+	for _, link := range lessonLinks(lesson) {
+		lines = append(lines, "Ссылка: "+link)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lessonLinks ищет ссылки на дистанционные занятия в аудитории и преподавателе
+func lessonLinks(lesson *parser.Lesson) []string {
+	return linkPattern.FindAllString(lesson.Room+" "+lesson.Teacher, -1)
+}
+
+// escapeICSText экранирует значение TEXT по RFC 5545
+func escapeICSText(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	)
+	return replacer.Replace(value)
+}
+
+// writeICSLine пишет строку с переносом по 75 байт (RFC 5545, 3.1),
+// не разрывая UTF-8 символы
+func writeICSLine(out *bufio.Writer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		out.WriteString(line[:cut])
+		out.WriteString("\r\n ")
+		line = line[cut:]
+		// Строка продолжения начинается с пробела, он тоже занимает байт
+		limit = 74
+	}
+	out.WriteString(line)
+	out.WriteString("\r\n")
+}
@@ -71,9 +71,8 @@ go mod tidy
 
 # Сборка
 echo "🔨 Сборка приложения..."
-go build -o test_parser test_parser.go parser.go
-go build -o main main.go parser.go
-chmod +x test_parser main
+go build -o msuparser .
+chmod +x msuparser
 
 echo "✅ Сборка завершена"
 
@@ -126,7 +125,7 @@ fi
 # Тестирование
 echo ""
 echo "🧪 Тестирование парсера..."
-./test_parser
+./msuparser fetch
 
 if [ ! -f schedule.json ]; then
     echo "❌ Не удалось создать schedule.json"
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Форматы журнала (LOG_FORMAT)
+const (
+	LogFormatConsole = "console" // Для запуска в терминале: время, сообщение и поля key=value
+	LogFormatText    = "text"    // logfmt, для journald и сборщиков логов
+	LogFormatJSON    = "json"    // Событие - строка JSON
+)
+
+// LogFormats список форматов журнала для справки и проверки конфига
+var LogFormats = []string{LogFormatConsole, LogFormatText, LogFormatJSON}
+
+// logLevel уровень журнала. Общий для всех обработчиков, поэтому LOG_LEVEL
+// применяется при перезагрузке конфига без перезапуска
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel разбирает LOG_LEVEL: debug, info, warn или error
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("ожидается debug, info, warn или error, получено %q", value)
+	}
+	return level, nil
+}
+
+// setupLogging направляет журнал (slog.Default) в stderr в формате LOG_FORMAT.
+// stdout остается для вывода команд, например fetch -o -
+func setupLogging(config Config) {
+	setLogLevel(config.LogLevel)
+	slog.SetDefault(newLogger(os.Stderr, config.LogFormat, logLevel))
+}
+
+// setLogLevel применяет LOG_LEVEL. Неверное значение уже отклонил Validate
+func setLogLevel(value string) {
+	if level, err := parseLogLevel(value); err == nil {
+		logLevel.Set(level)
+	}
+}
+
+// newLogger создает журнал в формате format
+func newLogger(w io.Writer, format string, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case LogFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts))
+	case LogFormatText:
+		return slog.New(slog.NewTextHandler(w, opts))
+	default:
+		return slog.New(&consoleHandler{mu: new(sync.Mutex), w: w, level: level})
+	}
+}
+
+// consoleHandler печатает события так же коротко, как раньше печатал бот:
+// "15:04:05 ✅ Загружено 2 пар lessons=2". Уровень пишется только у debug,
+// у предупреждений и ошибок он и так виден по значку в сообщении
+type consoleHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	attrs  []byte // Поля из WithAttrs, уже отформатированные
+	prefix string // Группы из WithGroup: "group."
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := make([]byte, 0, 256)
+	if !r.Time.IsZero() {
+		buf = r.Time.AppendFormat(buf, "15:04:05 ")
+	}
+	if r.Level < slog.LevelInfo {
+		buf = append(buf, "DEBUG "...)
+	}
+	buf = append(buf, r.Message...)
+	buf = append(buf, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		buf = appendConsoleAttr(buf, h.prefix, a)
+		return true
+	})
+	buf = append(buf, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf)
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.attrs = append([]byte(nil), h.attrs...)
+	for _, a := range attrs {
+		next.attrs = appendConsoleAttr(next.attrs, h.prefix, a)
+	}
+	return &next
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.prefix = h.prefix + name + "."
+	return &next
+}
+
+// appendConsoleAttr дописывает " key=value". Значения с пробелами и
+// переводами строк берутся в кавычки, чтобы событие оставалось одной строкой
+func appendConsoleAttr(buf []byte, prefix string, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return buf
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, member := range a.Value.Group() {
+			buf = appendConsoleAttr(buf, prefix, member)
+		}
+		return buf
+	}
+
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\r\n\"=") {
+		value = strconv.Quote(value)
+	}
+	buf = append(buf, ' ')
+	buf = append(buf, prefix...)
+	buf = append(buf, a.Key...)
+	buf = append(buf, '=')
+	return append(buf, value...)
+}
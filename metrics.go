@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Метрики отдаются на /metrics в текстовом формате Prometheus. Клиентская
+// библиотека не нужна: счетчики, значения и гистограммы ниже - все, что
+// используется, а формат описан в
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+// DefaultMetricsListen адрес /metrics, /healthz и /readyz по умолчанию:
+// только локально, чтобы они не оказались рядом с публичными календарями
+const DefaultMetricsListen = "127.0.0.1:9090"
+
+// Границы гистограмм в секундах
+var (
+	fetchDurationBuckets    = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	telegramDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+)
+
+var (
+	metricFetchStepDuration = newHistogramVec("msuparser_fetch_step_duration_seconds",
+		"Длительность этапов загрузки расписания", fetchDurationBuckets, "step")
+	metricFetchSteps = newCounterVec("msuparser_fetch_steps_total",
+		"Этапы загрузки расписания по результату (ok, error)", "step", "outcome")
+	metricScheduleUpdates = newCounterVec("msuparser_schedule_updates_total",
+		"Обновления расписания по результату: ok или тип ошибки (network, form, schedule, parse, validation, other)", "result")
+	metricLessonsParsed = newGauge("msuparser_lessons_parsed",
+		"Сколько пар разобрано при последней загрузке")
+
+	metricNotificationsQueued = newCounterVec("msuparser_notifications_queued_total",
+		"Напоминания, поставленные в очередь (lesson - перед парой, distance - утренняя сводка, broadcast - рассылка админа, change - сообщение об изменении пары)", "kind")
+	metricMessagesSent = newCounterVec("msuparser_messages_sent_total",
+		"Сообщения, доставленные в Telegram")
+	metricMessagesFailed = newCounterVec("msuparser_messages_failed_total",
+		"Сообщения, ушедшие в журнал недоставленных, по причине (rejected, chat_gone, attempts, overflow, stale)", "reason")
+
+	metricTelegramDuration = newHistogramVec("msuparser_telegram_request_duration_seconds",
+		"Длительность запросов к Bot API (getUpdates включает ожидание long polling)", telegramDurationBuckets, "method")
+	metricTelegramRequests = newCounterVec("msuparser_telegram_requests_total",
+		"Запросы к Bot API по результату: ok, код ошибки Telegram или error (сеть)", "method", "status")
+	metricTelegramRateLimited = newCounterVec("msuparser_telegram_rate_limited_total",
+		"Ответы 429 Too Many Requests от Bot API", "method")
+)
+
+// registeredMetrics все метрики пакета в порядке объявления
+var registeredMetrics []metricWriter
+
+type metricWriter interface {
+	writeTo(w *bufio.Writer)
+}
+
+// counterVec счетчик с метками
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64 // Ключ - значения меток через labelSeparator
+}
+
+const labelSeparator = "\xff"
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	registeredMetrics = append(registeredMetrics, c)
+	return c
+}
+
+// Inc увеличивает счетчик с заданными значениями меток
+func (c *counterVec) Inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(labelValues, labelSeparator)]++
+}
+
+func (c *counterVec) writeTo(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		writeSample(w, c.name, c.labels, splitLabelValues(key), c.values[key])
+	}
+}
+
+// gauge значение без меток, которое выставляется целиком
+type gauge struct {
+	name, help string
+
+	mu    sync.Mutex
+	value float64
+}
+
+func newGauge(name, help string) *gauge {
+	g := &gauge{name: name, help: help}
+	registeredMetrics = append(registeredMetrics, g)
+	return g
+}
+
+// Set выставляет значение
+func (g *gauge) Set(value float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.value = value
+}
+
+func (g *gauge) writeTo(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	writeGauge(w, g.name, g.help, g.value)
+}
+
+// histogramVec гистограмма с метками
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // По границам buckets, не накопительно
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogram)}
+	registeredMetrics = append(registeredMetrics, h)
+	return h
+}
+
+// Observe учитывает длительность d
+func (h *histogramVec) Observe(d time.Duration, labelValues ...string) {
+	value := d.Seconds()
+	key := strings.Join(labelValues, labelSeparator)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.series[key]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *histogramVec) writeTo(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range keys {
+		s := h.series[key]
+		values := splitLabelValues(key)
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", bucketLabels, append(values, formatFloat(bound)), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", bucketLabels, append(values, "+Inf"), float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, values, s.sum)
+		writeSample(w, h.name+"_count", h.labels, values, float64(s.count))
+	}
+}
+
+// observeFetchStep учитывает этап загрузки расписания, см. ParserConfig.OnStep
+func observeFetchStep(step string, duration time.Duration, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	metricFetchStepDuration.Observe(duration, step)
+	metricFetchSteps.Inc(step, outcome)
+}
+
+// StartMetricsServer запускает отдельный сервер метрик и проверок состояния
+// на METRICS_LISTEN. Если адрес пуст или совпадает с HTTP_LISTEN, отдельный
+// сервер не нужен
+func (bot *TimetableBot) StartMetricsServer(ctx context.Context) {
+	if bot.metricsListen == "" || bot.metricsListen == bot.httpListen {
+		return
+	}
+	mux := http.NewServeMux()
+	bot.handleMonitoring(mux)
+	bot.startServer(ctx, "Сервер метрик", bot.metricsListen, mux)
+}
+
+// handleMonitoring подключает /metrics, /healthz и /readyz
+func (bot *TimetableBot) handleMonitoring(mux *http.ServeMux) {
+	mux.Handle("/metrics", bot.MetricsHandler())
+	mux.Handle("/healthz", bot.HealthzHandler())
+	mux.Handle("/readyz", bot.ReadyzHandler())
+}
+
+// MetricsHandler отдает метрики пакета и состояние бота на момент запроса
+func (bot *TimetableBot) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bot.writeMetrics(w)
+	})
+}
+
+func (bot *TimetableBot) writeMetrics(out io.Writer) {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+
+	for _, metric := range registeredMetrics {
+		metric.writeTo(w)
+	}
+
+	build := buildInfo()
+	writeHeader(w, "msuparser_build_info", "Версия сборки, значение всегда 1", "gauge")
+	writeSample(w, "msuparser_build_info", []string{"version", "commit", "go_version"},
+		[]string{build.Version, build.Commit, build.GoVersion}, 1)
+
+	writeGauge(w, "msuparser_schedule_lessons", "Пар в текущем расписании", float64(len(bot.Schedule())))
+	if fetchedAt := bot.ScheduleModTime(); !fetchedAt.IsZero() {
+		writeGauge(w, "msuparser_schedule_age_seconds", "Сколько секунд назад расписание получено с сайта",
+			bot.now().Sub(fetchedAt).Seconds())
+	}
+	writeGauge(w, "msuparser_schedule_fetch_failures", "Неудачных обновлений расписания подряд",
+		float64(bot.fetchHealth.Status().Failures))
+	writeGauge(w, "msuparser_subscribers", "Подписчиков, получающих напоминания", float64(len(bot.subscribers.All())))
+	if bot.outbox != nil {
+		writeGauge(w, "msuparser_outbox_pending", "Сообщений в очереди на отправку", float64(bot.outbox.Pending()))
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+func writeGauge(w *bufio.Writer, name, help string, value float64) {
+	writeHeader(w, name, help, "gauge")
+	writeSample(w, name, nil, nil, value)
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			var v string
+			if i < len(values) {
+				v = values[i]
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabelValue(v))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func splitLabelValues(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, labelSeparator)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
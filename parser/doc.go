@@ -0,0 +1,37 @@
+// Package parser получает расписание занятий МГУ ВШГА с tt.audit.msu.ru.
+//
+// Парсер работает только через HTTP: берет CSRF токен со страницы
+// расписания, отправляет форму с факультетом, курсом и группой и разбирает
+// таблицу из ответа. Браузер не нужен.
+//
+// Пример:
+//
+//	p, err := parser.NewScheduleParser(parser.ParserConfig{
+//		FacultyID: 3,
+//		Course:    3,
+//		GroupID:   52,
+//	})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	lessons, err := p.GetSchedule()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	for _, lesson := range lessons {
+//		fmt.Printf("%s %s-%s %s (%s)\n",
+//			lesson.Date, lesson.TimeStart, lesson.TimeEnd, lesson.SubjectName(), lesson.Room)
+//	}
+//
+// FetchSchedule возвращает то же расписание вместе с метаданными выгрузки
+// (Schedule): временем, диапазоном дат, адресом и группой. В этом виде его
+// хранит schedule.json, см. MarshalSchedule и UnmarshalSchedule.
+//
+// Этапы загрузки (StepForm, StepSchedule, StepParse) с длительностью
+// пишутся в ParserConfig.Logger на уровне debug.
+//
+// Сохраненную страницу можно разобрать без сети через ParseSchedule.
+// Даты в Lesson имеют формат ДД.ММ.ГГГГ, время - ЧЧ:ММ по Москве.
+package parser
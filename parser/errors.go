@@ -0,0 +1,28 @@
+package parser
+
+import "errors"
+
+// Этапы получения расписания, на которых может произойти ошибка
+const (
+	StepForm     = "form"     // Страница с формой и CSRF токен
+	StepSchedule = "schedule" // Отправка формы
+	StepParse    = "parse"    // Разбор таблицы расписания
+)
+
+// StepError ошибка FetchSchedule с указанием этапа. Сетевые ошибки можно
+// отличить через errors.As(err, &netErr) с net.Error
+type StepError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// ErrInvalidSchedule оборачивают ошибки Validate
+var ErrInvalidSchedule = errors.New("расписание не прошло проверку")
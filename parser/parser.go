@@ -1,12 +1,16 @@
-package main
+package parser
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,11 +31,56 @@ type Lesson struct {
 	Notification time.Time `json:"-"` // Для бота
 }
 
+// ID возвращает стабильный идентификатор пары: группа, дата, время начала и предмет.
+// Аудитория и преподаватель в него не входят, чтобы их изменение не создавало новую пару
+func (l Lesson) ID() string {
+	sum := sha1.Sum([]byte(strings.Join([]string{l.Group, l.Date, l.TimeStart, l.Subject}, "|")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SubjectName возвращает название предмета без типа занятия
+func (l Lesson) SubjectName() string {
+	name, _ := splitSubject(l.Subject)
+	return name
+}
+
+// LessonType возвращает тип занятия из квадратных скобок ("Лек", "Сем"), если он указан
+func (l Lesson) LessonType() string {
+	_, lessonType := splitSubject(l.Subject)
+	return lessonType
+}
+
+// splitSubject разбирает Subject вида "Название [Тип]"
+func splitSubject(subject string) (name, lessonType string) {
+	subject = strings.TrimSpace(subject)
+	i := strings.LastIndex(subject, "[")
+	if i < 0 || !strings.HasSuffix(subject, "]") {
+		return subject, ""
+	}
+	return strings.TrimSpace(subject[:i]), strings.TrimSpace(subject[i+1 : len(subject)-1])
+}
+
 // ParserConfig содержит конфигурацию для парсера
 type ParserConfig struct {
-	FacultyID int
-	Course    int
-	GroupID   int
+	FacultyID int `json:"faculty_id"`
+	Course    int `json:"course"`
+	GroupID   int `json:"group_id"`
+	// Название группы в парах: по нему строятся ID пар и ссылка на календарь группы
+	GroupName string `json:"group_name,omitempty"`
+
+	// На сколько дней вперед запрашивать расписание, 0 - на месяц
+	Days int `json:"days,omitempty"`
+	// Адрес сайта расписания, пусто - https://tt.audit.msu.ru (для тестов)
+	BaseURL string `json:"-"`
+	// Часовой пояс, в котором считается сегодняшняя дата, nil - московское время
+	Location *time.Location `json:"-"`
+	// Источник текущего времени, nil - time.Now
+	Now func() time.Time `json:"-"`
+	// Журнал этапов загрузки (уровень debug), nil - slog.Default()
+	Logger *slog.Logger `json:"-"`
+	// Вызывается после каждого этапа загрузки с его длительностью и ошибкой,
+	// например для метрик; nil - не вызывается
+	OnStep func(step string, duration time.Duration, err error) `json:"-"`
 }
 
 // ScheduleParser парсер расписания
@@ -53,37 +102,51 @@ func NewScheduleParser(config ParserConfig) (*ScheduleParser, error) {
 		Timeout: 30 * time.Second,
 	}
 
+	baseURL := "https://tt.audit.msu.ru"
+	if config.BaseURL != "" {
+		baseURL = strings.TrimSuffix(config.BaseURL, "/")
+	}
+
 	return &ScheduleParser{
 		client:  client,
 		config:  config,
-		baseURL: "https://tt.audit.msu.ru",
+		baseURL: baseURL,
 	}, nil
 }
 
-// getCSRFToken получает CSRF токен со страницы
-func (p *ScheduleParser) getCSRFToken() (string, error) {
+// fetchFormPage загружает страницу с формой выбора группы
+func (p *ScheduleParser) fetchFormPage() (*goquery.Document, error) {
 	url := p.baseURL + "/time-table/group"
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", fmt.Errorf("ошибка создания запроса: %w", err)
+		return nil, fmt.Errorf("ошибка создания запроса: %w", err)
 	}
 
 	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("ошибка выполнения запроса: %w", err)
+		return nil, fmt.Errorf("ошибка выполнения запроса: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("неожиданный статус код: %d", resp.StatusCode)
+		return nil, fmt.Errorf("неожиданный статус код: %d", resp.StatusCode)
 	}
 
 	doc, err := goquery.NewDocumentFromReader(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("ошибка парсинга HTML: %w", err)
+		return nil, fmt.Errorf("ошибка парсинга HTML: %w", err)
+	}
+	return doc, nil
+}
+
+// getCSRFToken получает CSRF токен со страницы
+func (p *ScheduleParser) getCSRFToken() (string, error) {
+	doc, err := p.fetchFormPage()
+	if err != nil {
+		return "", err
 	}
 
 	// Попробуем найти токен в input
@@ -101,18 +164,62 @@ func (p *ScheduleParser) getCSRFToken() (string, error) {
 	return "", fmt.Errorf("CSRF токен не найден")
 }
 
-// generateDateRange генерирует даты для запроса (текущая дата + 1 месяц)
-func generateDateRange() (startDate, endDate string) {
-	now := time.Now()
-	start := now.Format("02.01.2006")
-	end := now.AddDate(0, 1, 0).Format("02.01.2006")
-	return start, end
+// Option вариант из выпадающего списка формы расписания
+type Option struct {
+	Value string
+	Label string
 }
 
-// fetchSchedule выполняет POST запрос и возвращает HTML с расписанием
-func (p *ScheduleParser) fetchSchedule(csrfToken string) (io.ReadCloser, error) {
-	startDate, endDate := generateDateRange()
+// FormOptions возвращает варианты из выпадающих списков формы расписания.
+// Ключи - поля формы: "facultyId", "course", "groupId". Список групп сайт
+// может подгружать только после выбора факультета и курса, тогда он пуст
+func (p *ScheduleParser) FormOptions() (map[string][]Option, error) {
+	doc, err := p.fetchFormPage()
+	if err != nil {
+		return nil, err
+	}
 
+	options := make(map[string][]Option)
+	for _, field := range []string{"facultyId", "course", "groupId"} {
+		selector := fmt.Sprintf("select[name='TimeTableForm[%s]'] option", field)
+		doc.Find(selector).Each(func(_ int, option *goquery.Selection) {
+			value, _ := option.Attr("value")
+			if strings.TrimSpace(value) == "" {
+				// Пустой вариант - подсказка "Выберите..."
+				return
+			}
+			options[field] = append(options[field], Option{
+				Value: value,
+				Label: strings.TrimSpace(option.Text()),
+			})
+		})
+	}
+
+	return options, nil
+}
+
+// dateRange генерирует даты для запроса: с сегодняшнего дня на Days дней (или на месяц)
+func (p *ScheduleParser) dateRange(now time.Time) (startDate, endDate string) {
+	loc := p.config.Location
+	if loc == nil {
+		loc = time.FixedZone("MSK", 3*60*60)
+	}
+	now = now.In(loc)
+
+	end := now.AddDate(0, 1, 0)
+	if p.config.Days > 0 {
+		end = now.AddDate(0, 0, p.config.Days)
+	}
+	return now.Format("02.01.2006"), end.Format("02.01.2006")
+}
+
+// scheduleURL адрес, на который отправляется форма расписания
+func (p *ScheduleParser) scheduleURL() string {
+	return p.baseURL + "/time-table/group?type=0"
+}
+
+// fetchSchedule выполняет POST запрос и возвращает HTML с расписанием
+func (p *ScheduleParser) fetchSchedule(csrfToken, startDate, endDate string) (io.ReadCloser, error) {
 	// Формируем payload
 	data := url.Values{}
 	data.Set("_csrf-frontend", csrfToken)
@@ -125,8 +232,7 @@ func (p *ScheduleParser) fetchSchedule(csrfToken string) (io.ReadCloser, error)
 	data.Set("TimeTableForm[indicationDays]", "5")
 	data.Set("time-table-type", "0")
 
-	reqURL := p.baseURL + "/time-table/group?type=0"
-	req, err := http.NewRequest("POST", reqURL, strings.NewReader(data.Encode()))
+	req, err := http.NewRequest("POST", p.scheduleURL(), strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("ошибка создания запроса: %w", err)
 	}
@@ -199,10 +305,10 @@ func parseLessonData(dataContent string) (name, lessonType, room, teacher string
 	return
 }
 
-// parseSchedule парсит HTML и извлекает расписание
-func (p *ScheduleParser) parseSchedule(body io.ReadCloser) ([]Lesson, error) {
-	defer body.Close()
-
+// ParseSchedule извлекает расписание из HTML страницы tt.audit.msu.ru
+// (таблица #timeTable). Полезно для разбора сохраненных страниц без сети.
+// group - название группы, которое получат все пары
+func ParseSchedule(body io.Reader, group string) ([]Lesson, error) {
 	doc, err := goquery.NewDocumentFromReader(body)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка парсинга HTML: %w", err)
@@ -304,7 +410,7 @@ func (p *ScheduleParser) parseSchedule(body io.ReadCloser) ([]Lesson, error) {
 				TimeEnd:      timeEnd,
 				Date:         date,
 				Weekday:      dayOfWeek,
-				Group:        "303",
+				Group:        group,
 			}
 
 			lessons = append(lessons, lesson)
@@ -316,23 +422,72 @@ func (p *ScheduleParser) parseSchedule(body io.ReadCloser) ([]Lesson, error) {
 
 // GetSchedule получает расписание (главный метод)
 func (p *ScheduleParser) GetSchedule() ([]Lesson, error) {
+	schedule, err := p.FetchSchedule()
+	if err != nil {
+		return nil, err
+	}
+	return schedule.Lessons, nil
+}
+
+// FetchSchedule получает расписание вместе со сведениями о выгрузке:
+// когда, за какие даты, откуда и для какой группы
+func (p *ScheduleParser) FetchSchedule() (*Schedule, error) {
+	log := p.config.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	var started time.Time
+	done := func(step string, err error) {
+		if p.config.OnStep != nil {
+			p.config.OnStep(step, time.Since(started), err)
+		}
+	}
+
 	// Шаг 1: Получаем CSRF токен
+	started = time.Now()
 	csrfToken, err := p.getCSRFToken()
+	done(StepForm, err)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка получения CSRF токена: %w", err)
+		return nil, &StepError{Step: StepForm, Err: fmt.Errorf("ошибка получения CSRF токена: %w", err)}
 	}
+	log.Debug("Получен CSRF токен", "step", StepForm, "duration", time.Since(started))
 
 	// Шаг 2: Получаем HTML с расписанием
-	body, err := p.fetchSchedule(csrfToken)
+	now := time.Now()
+	if p.config.Now != nil {
+		now = p.config.Now()
+	}
+	startDate, endDate := p.dateRange(now)
+	started = time.Now()
+	body, err := p.fetchSchedule(csrfToken, startDate, endDate)
+	done(StepSchedule, err)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка получения расписания: %w", err)
+		return nil, &StepError{Step: StepSchedule, Err: fmt.Errorf("ошибка получения расписания: %w", err)}
 	}
+	log.Debug("Получена страница расписания", "step", StepSchedule, "duration", time.Since(started),
+		"group_id", p.config.GroupID, "from", startDate, "to", endDate)
 
 	// Шаг 3: Парсим HTML
-	lessons, err := p.parseSchedule(body)
+	defer body.Close()
+	started = time.Now()
+	group := p.config.GroupName
+	if group == "" {
+		group = strconv.Itoa(p.config.GroupID)
+	}
+	lessons, err := ParseSchedule(body, group)
+	done(StepParse, err)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка парсинга расписания: %w", err)
+		return nil, &StepError{Step: StepParse, Err: fmt.Errorf("ошибка парсинга расписания: %w", err)}
 	}
-
-	return lessons, nil
+	log.Debug("Расписание разобрано", "step", StepParse, "duration", time.Since(started), "lessons", len(lessons))
+
+	return &Schedule{
+		SchemaVersion: ScheduleSchemaVersion,
+		FetchedAt:     now,
+		DateStart:     startDate,
+		DateEnd:       endDate,
+		SourceURL:     p.scheduleURL(),
+		Config:        p.config,
+		Lessons:       lessons,
+	}, nil
 }
@@ -0,0 +1,98 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestSite поднимает сайт расписания с одной парой. csrf - значение
+// токена на странице формы, status - ответ на отправку формы
+func newTestSite(t *testing.T, csrf string, status int) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /time-table/group", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<form><input type="hidden" name="_csrf-frontend" value="%s"></form>`, csrf)
+	})
+	mux.HandleFunc("POST /time-table/group", func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		fmt.Fprint(w, `<table id="timeTable">
+<tr><th class="headday">20.10.2026</th></tr>
+<tr><th class="headcol"><span class="start">09:00</span><span class="end">10:30</span></th>
+<td><div data-toggle="popover" title="20.10.2026 1 пара" data-content="Право [Семинар]&lt;br&gt;ауд. 101&lt;br&gt;Иванов И.И."></div></td></tr>
+</table>`)
+	})
+	site := httptest.NewServer(mux)
+	t.Cleanup(site.Close)
+	return site
+}
+
+// Имена этапов - значения метки step в метриках и типы ошибок обновления
+// у бота, поэтому менять их нельзя
+func TestFetchScheduleSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		csrf    string
+		status  int
+		steps   []string
+		errStep string
+	}{
+		{"успех", "token", http.StatusOK, []string{StepForm, StepSchedule, StepParse}, ""},
+		{"нет CSRF токена", "", http.StatusOK, []string{StepForm}, StepForm},
+		{"ошибка сайта", "token", http.StatusInternalServerError, []string{StepForm, StepSchedule}, StepSchedule},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var steps []string
+			var failed []string
+			scheduleParser, err := NewScheduleParser(ParserConfig{
+				GroupName: "303",
+				BaseURL:   newTestSite(t, tt.csrf, tt.status).URL,
+				OnStep: func(step string, duration time.Duration, err error) {
+					steps = append(steps, step)
+					if err != nil {
+						failed = append(failed, step)
+					}
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			schedule, err := scheduleParser.FetchSchedule()
+			if !reflect.DeepEqual(steps, tt.steps) {
+				t.Errorf("этапы %v, ожидалось %v", steps, tt.steps)
+			}
+			if tt.errStep == "" {
+				if err != nil {
+					t.Fatalf("FetchSchedule: %v", err)
+				}
+				if len(schedule.Lessons) != 1 || schedule.Lessons[0].Group != "303" {
+					t.Errorf("пары: %+v", schedule.Lessons)
+				}
+				return
+			}
+
+			var stepErr *StepError
+			if !errors.As(err, &stepErr) || stepErr.Step != tt.errStep {
+				t.Fatalf("ошибка %v, ожидался StepError этапа %q", err, tt.errStep)
+			}
+			if want := []string{tt.errStep}; !reflect.DeepEqual(failed, want) {
+				t.Errorf("OnStep с ошибкой: %v, ожидалось %v", failed, want)
+			}
+		})
+	}
+}
+
+func TestStepNames(t *testing.T) {
+	got := []string{StepForm, StepSchedule, StepParse}
+	if want := []string{"form", "schedule", "parse"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("имена этапов %v, ожидалось %v", got, want)
+	}
+}
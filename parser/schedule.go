@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ScheduleSchemaVersion текущая версия формата schedule.json
+const ScheduleSchemaVersion = 1
+
+// Schedule выгрузка расписания с метаданными. В таком виде она хранится
+// в schedule.json:
+//
+//	{
+//	  "schema_version": 1,
+//	  "fetched_at": "2026-10-19T02:00:03+03:00",
+//	  "date_start": "19.10.2026",
+//	  "date_end": "19.11.2026",
+//	  "source_url": "https://tt.audit.msu.ru/time-table/group?type=0",
+//	  "config": {"faculty_id": 3, "course": 3, "group_id": 52},
+//	  "lessons": [...]
+//	}
+type Schedule struct {
+	SchemaVersion int          `json:"schema_version"`
+	FetchedAt     time.Time    `json:"fetched_at"`
+	DateStart     string       `json:"date_start,omitempty"` // ДД.ММ.ГГГГ, включительно
+	DateEnd       string       `json:"date_end,omitempty"`
+	SourceURL     string       `json:"source_url,omitempty"`
+	Config        ParserConfig `json:"config"`
+	Lessons       []Lesson     `json:"lessons"`
+}
+
+// MarshalSchedule кодирует выгрузку в формат schedule.json
+func MarshalSchedule(schedule *Schedule) ([]byte, error) {
+	data, err := json.MarshalIndent(schedule, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// UnmarshalSchedule разбирает schedule.json. Старый формат - голый массив
+// пар - тоже принимается: у такой выгрузки SchemaVersion равна 0, а
+// метаданные пустые
+func UnmarshalSchedule(data []byte) (*Schedule, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var lessons []Lesson
+		if err := json.Unmarshal(data, &lessons); err != nil {
+			return nil, err
+		}
+		return &Schedule{Lessons: lessons}, nil
+	}
+
+	var schedule Schedule
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return nil, err
+	}
+	if schedule.SchemaVersion > ScheduleSchemaVersion {
+		return nil, fmt.Errorf("версия формата %d новее поддерживаемой %d, обновите msuparser",
+			schedule.SchemaVersion, ScheduleSchemaVersion)
+	}
+	if schedule.Lessons == nil {
+		return nil, fmt.Errorf("в файле нет поля lessons")
+	}
+	return &schedule, nil
+}
@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLessons() []Lesson {
+	return []Lesson{{
+		Subject:      "Право [Семинар]",
+		Teacher:      "Иванов И.И.",
+		Room:         "101",
+		LessonNumber: "1",
+		TimeStart:    "09:00",
+		TimeEnd:      "10:30",
+		Date:         "20.10.2026",
+		Weekday:      "Вторник",
+		Group:        "303",
+	}}
+}
+
+func TestScheduleRoundTrip(t *testing.T) {
+	schedule := &Schedule{
+		SchemaVersion: ScheduleSchemaVersion,
+		FetchedAt:     time.Date(2026, 10, 19, 2, 0, 3, 0, time.FixedZone("MSK", 3*60*60)),
+		DateStart:     "19.10.2026",
+		DateEnd:       "19.11.2026",
+		SourceURL:     "https://tt.audit.msu.ru/time-table/group?type=0",
+		Config:        ParserConfig{FacultyID: 3, Course: 3, GroupID: 52, GroupName: "303"},
+		Lessons:       testLessons(),
+	}
+	data, err := MarshalSchedule(schedule)
+	if err != nil {
+		t.Fatalf("MarshalSchedule: %v", err)
+	}
+
+	got, err := UnmarshalSchedule(data)
+	if err != nil {
+		t.Fatalf("UnmarshalSchedule: %v", err)
+	}
+	if !got.FetchedAt.Equal(schedule.FetchedAt) {
+		t.Errorf("FetchedAt = %s, ожидалось %s", got.FetchedAt, schedule.FetchedAt)
+	}
+	got.FetchedAt = schedule.FetchedAt
+	if !reflect.DeepEqual(got, schedule) {
+		t.Errorf("после разбора:\n%+v\nожидалось:\n%+v", got, schedule)
+	}
+}
+
+func TestUnmarshalScheduleLegacyArray(t *testing.T) {
+	// Старый schedule.json - голый массив пар без метаданных
+	data := `
+	[{"subject": "Право [Семинар]", "teacher": "Иванов И.И.", "room": "101", "lesson_number": "1",
+	  "time_start": "09:00", "time_end": "10:30", "date": "20.10.2026", "weekday": "Вторник", "group": "303"}]`
+
+	got, err := UnmarshalSchedule([]byte(data))
+	if err != nil {
+		t.Fatalf("UnmarshalSchedule: %v", err)
+	}
+	if got.SchemaVersion != 0 || !got.FetchedAt.IsZero() || got.SourceURL != "" {
+		t.Errorf("у старого формата есть метаданные: %+v", got)
+	}
+	if !reflect.DeepEqual(got.Lessons, testLessons()) {
+		t.Errorf("пары: %+v", got.Lessons)
+	}
+}
+
+func TestUnmarshalScheduleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"новая версия", `{"schema_version": 99, "lessons": []}`, "новее поддерживаемой"},
+		{"без пар", `{"schema_version": 1}`, "нет поля lessons"},
+		{"сломанный массив", `[{"subject": 1}]`, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := UnmarshalSchedule([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ошибка %v, ожидалось %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package parser
+
+import (
+	"fmt"
+	"time"
+)
+
+// Validate проверяет, что разбор страницы дал правдоподобное расписание.
+// Если сайт поменял верстку, парсер может вернуть пустой список или пары
+// без даты и времени, и такое расписание не должно заменить рабочее
+func Validate(lessons []Lesson) error {
+	if len(lessons) == 0 {
+		return fmt.Errorf("%w: в расписании нет ни одной пары", ErrInvalidSchedule)
+	}
+
+	for i, lesson := range lessons {
+		if lesson.Subject == "" {
+			return fmt.Errorf("%w: пара %d (%s %s): пустое название предмета", ErrInvalidSchedule, i+1, lesson.Date, lesson.TimeStart)
+		}
+		if _, err := time.Parse("02.01.2006", lesson.Date); err != nil {
+			return fmt.Errorf("%w: пара %d: неверная дата %q", ErrInvalidSchedule, i+1, lesson.Date)
+		}
+		start, err := time.Parse("15:04", lesson.TimeStart)
+		if err != nil {
+			return fmt.Errorf("%w: пара %d (%s): неверное время начала %q", ErrInvalidSchedule, i+1, lesson.Date, lesson.TimeStart)
+		}
+		end, err := time.Parse("15:04", lesson.TimeEnd)
+		if err != nil {
+			return fmt.Errorf("%w: пара %d (%s): неверное время конца %q", ErrInvalidSchedule, i+1, lesson.Date, lesson.TimeEnd)
+		}
+		if !end.After(start) {
+			return fmt.Errorf("%w: пара %d (%s): конец %s не позже начала %s", ErrInvalidSchedule, i+1, lesson.Date, lesson.TimeEnd, lesson.TimeStart)
+		}
+	}
+
+	return nil
+}
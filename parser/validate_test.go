@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	if err := Validate(testLessons()); err != nil {
+		t.Fatalf("правильное расписание не прошло проверку: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Lesson)
+		want   string
+	}{
+		{"пустой предмет", func(l *Lesson) { l.Subject = "" }, "пустое название предмета"},
+		{"дата", func(l *Lesson) { l.Date = "2026-10-20" }, "неверная дата"},
+		{"несуществующая дата", func(l *Lesson) { l.Date = "31.02.2026" }, "неверная дата"},
+		{"время начала", func(l *Lesson) { l.TimeStart = "9 утра" }, "неверное время начала"},
+		{"время конца", func(l *Lesson) { l.TimeEnd = "25:00" }, "неверное время конца"},
+		{"конец раньше начала", func(l *Lesson) { l.TimeEnd = "08:30" }, "не позже начала"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lessons := testLessons()
+			tt.change(&lessons[0])
+			err := Validate(lessons)
+			if !errors.Is(err, ErrInvalidSchedule) {
+				t.Fatalf("ошибка %v не оборачивает ErrInvalidSchedule", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ошибка %q, ожидалось %q", err, tt.want)
+			}
+		})
+	}
+
+	if err := Validate(nil); !errors.Is(err, ErrInvalidSchedule) {
+		t.Errorf("пустое расписание: %v", err)
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DeadLettersFile = "dead_letters.jsonl"
+	OutboxFile      = "outbox.json" // Сообщения, которые не успели отправить до остановки
+
+	// Ограничения Telegram: ~30 сообщений в секунду всего,
+	// 1 в секунду в личный чат и 20 в минуту в группу
+	outboxGlobalInterval = time.Second / 30
+	outboxChatInterval   = time.Second
+	outboxGroupInterval  = 3 * time.Second
+
+	outboxMaxAttempts = 6
+	outboxMaxBackoff  = 5 * time.Minute
+	outboxMaxPending  = 10000
+
+	// Сообщения из outbox.json старше этого после перезапуска уже не нужны:
+	// напоминание о прошедшей паре только мешает
+	outboxMaxSpoolAge = 15 * time.Minute
+)
+
+// OutgoingMessage сообщение в очереди на отправку
+type OutgoingMessage struct {
+	ChatID    int64     `json:"chat_id"`
+	Text      string    `json:"text"`
+	Attempts  int       `json:"attempts"`
+	Queued    time.Time `json:"queued"`
+	notBefore time.Time // Не отправлять раньше (retry_after или backoff)
+
+	// Необязательные: правка уже отправленного сообщения вместо нового,
+	// ответ на сообщение и метка для хука Sent (ID пары у напоминаний)
+	EditMessageID int    `json:"edit_message_id,omitempty"`
+	ReplyTo       int    `json:"reply_to,omitempty"`
+	Ref           string `json:"ref,omitempty"`
+}
+
+// deadLetter запись о сообщении, которое не удалось доставить
+type deadLetter struct {
+	Time     time.Time `json:"time"`
+	ChatID   int64     `json:"chat_id"`
+	Text     string    `json:"text"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+}
+
+// Outbox очередь исходящих сообщений с повторами и ограничением скорости.
+// Сообщения одного чата отправляются строго по порядку
+type Outbox struct {
+	telegram    *TelegramClient
+	deadLetters string
+	hooks       OutboxHooks
+
+	mu         sync.Mutex
+	pending    []*OutgoingMessage
+	chatNext   map[int64]time.Time
+	globalNext time.Time
+	wake       chan struct{}
+}
+
+// OutboxHooks связывают очередь с настройками чатов. Любой из них может быть nil
+type OutboxHooks struct {
+	ChatGone func(chatID int64)     // Бот заблокирован или удален из чата
+	Migrated func(from, to int64)   // Группа стала супергруппой с новым ID
+	Thread   func(chatID int64) int // Тема форума для сообщений в чат, 0 - общая
+
+	// Новое сообщение с Ref доставлено, sent - ответ Telegram с его message_id
+	Sent func(msg OutgoingMessage, sent Message)
+
+	// Выбранную тему удалили или закрыли. Сообщение отправляется еще раз,
+	// и если хук сбросил тему, оно придет в общую
+	ThreadGone func(chatID int64)
+}
+
+// NewOutbox создает очередь. Неотправленные сообщения пишутся в deadLetters
+func NewOutbox(telegram *TelegramClient, deadLetters string, hooks OutboxHooks) *Outbox {
+	return &Outbox{
+		telegram:    telegram,
+		deadLetters: deadLetters,
+		hooks:       hooks,
+		chatNext:    make(map[int64]time.Time),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Enqueue ставит сообщение в очередь
+func (o *Outbox) Enqueue(chatID int64, text string) error {
+	return o.Push(OutgoingMessage{ChatID: chatID, Text: text})
+}
+
+// Push ставит в очередь сообщение с дополнительными параметрами
+func (o *Outbox) Push(msg OutgoingMessage) error {
+	msg.Attempts = 0
+	msg.Queued = time.Now()
+
+	o.mu.Lock()
+	if len(o.pending) >= outboxMaxPending {
+		o.mu.Unlock()
+		err := fmt.Errorf("очередь переполнена (%d сообщений)", outboxMaxPending)
+		o.deadLetter(&msg, err)
+		metricMessagesFailed.Inc("overflow")
+		return err
+	}
+	o.pending = append(o.pending, &msg)
+	o.mu.Unlock()
+
+	o.signal()
+	return nil
+}
+
+// Pending возвращает число сообщений в очереди
+func (o *Outbox) Pending() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending)
+}
+
+// Snapshot возвращает копию сообщений в очереди в порядке отправки
+func (o *Outbox) Snapshot() []OutgoingMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pending := make([]OutgoingMessage, len(o.pending))
+	for i, msg := range o.pending {
+		pending[i] = *msg
+	}
+	return pending
+}
+
+func (o *Outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run отправляет сообщения из очереди, пока не отменен ctx. Начатая
+// отправка доводится до конца. Блокирует, запускается в горутине
+func (o *Outbox) Run(ctx context.Context) {
+	o.loop(ctx, false)
+}
+
+// Drain досылает оставшиеся сообщения при остановке: возвращается, когда
+// очередь опустела или истек ctx, с числом неотправленных сообщений
+func (o *Outbox) Drain(ctx context.Context) int {
+	o.loop(ctx, true)
+	return o.Pending()
+}
+
+func (o *Outbox) loop(ctx context.Context, untilEmpty bool) {
+	for ctx.Err() == nil {
+		msg, wait := o.next(time.Now())
+		if msg == nil {
+			if wait == 0 && untilEmpty {
+				return
+			}
+
+			// wait == 0: очередь пуста, ждем нового сообщения
+			var timer *time.Timer
+			var timeout <-chan time.Time
+			if wait > 0 {
+				timer = time.NewTimer(wait)
+				timeout = timer.C
+			}
+			select {
+			case <-o.wake:
+			case <-timeout:
+			case <-ctx.Done():
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		}
+
+		o.send(ctx, msg)
+	}
+}
+
+// Save записывает неотправленные сообщения в файл, чтобы отправить их
+// после перезапуска. Пустая очередь удаляет файл
+func (o *Outbox) Save(path string) error {
+	o.mu.Lock()
+	pending := append([]*OutgoingMessage(nil), o.pending...)
+	o.mu.Unlock()
+
+	if len(pending) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0644)
+}
+
+// Load возвращает в очередь сообщения, сохраненные при прошлой остановке.
+// Устаревшие уходят в журнал недоставленных. Возвращает число поставленных в очередь
+func (o *Outbox) Load(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var saved []*OutgoingMessage
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+
+	now := time.Now()
+	var fresh []*OutgoingMessage
+	for _, msg := range saved {
+		if now.Sub(msg.Queued) > outboxMaxSpoolAge {
+			o.deadLetter(msg, fmt.Errorf("устарело за время остановки бота (в очереди с %s)", msg.Queued.Format("02.01.2006 15:04")))
+			metricMessagesFailed.Inc("stale")
+			continue
+		}
+		fresh = append(fresh, msg)
+	}
+
+	o.mu.Lock()
+	o.pending = append(fresh, o.pending...)
+	o.mu.Unlock()
+	o.signal()
+
+	// Файл больше не нужен: при следующей остановке Save запишет его заново
+	if err := os.Remove(path); err != nil {
+		return len(fresh), err
+	}
+	return len(fresh), nil
+}
+
+// next выбирает сообщение, которое можно отправить сейчас, и убирает его из очереди.
+// Если такого нет, возвращает время ожидания (0 - очередь пуста)
+func (o *Outbox) next(now time.Time) (*OutgoingMessage, time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.pending) == 0 {
+		return nil, 0
+	}
+	if now.Before(o.globalNext) {
+		return nil, o.globalNext.Sub(now)
+	}
+
+	var wait time.Duration
+	seen := make(map[int64]bool)
+	for i, msg := range o.pending {
+		// Только первое сообщение каждого чата, чтобы не нарушить порядок
+		if seen[msg.ChatID] {
+			continue
+		}
+		seen[msg.ChatID] = true
+
+		ready := msg.notBefore
+		if next := o.chatNext[msg.ChatID]; next.After(ready) {
+			ready = next
+		}
+
+		if !now.Before(ready) {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			o.globalNext = now.Add(outboxGlobalInterval)
+			o.chatNext[msg.ChatID] = now.Add(chatInterval(msg.ChatID))
+			return msg, 0
+		}
+		if d := ready.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+
+	return nil, wait
+}
+
+// send отправляет сообщение и решает, повторять ли его при ошибке. Запрос
+// прерывается вместе с ctx, тогда сообщение остается в очереди и при
+// остановке попадает в outbox.json
+func (o *Outbox) send(ctx context.Context, msg *OutgoingMessage) {
+	msg.Attempts++
+	// Тема берется в момент отправки, чтобы /topic действовал и на уже
+	// поставленные в очередь сообщения
+	thread := 0
+	if o.hooks.Thread != nil && msg.EditMessageID == 0 {
+		thread = o.hooks.Thread(msg.ChatID)
+	}
+
+	var sent Message
+	var err error
+	if msg.EditMessageID != 0 {
+		err = o.telegram.EditMessageText(ctx, EditMessageTextRequest{
+			ChatID:    msg.ChatID,
+			MessageID: msg.EditMessageID,
+			Text:      msg.Text,
+			ParseMode: "HTML",
+		})
+		if isNotModified(err) {
+			err = nil
+		}
+	} else {
+		sent, err = o.telegram.SendMessage(ctx, SendMessageRequest{
+			ChatID:          msg.ChatID,
+			MessageThreadID: thread,
+			Text:            msg.Text,
+			ParseMode:       "HTML",
+			ReplyTo:         msg.ReplyTo,
+		})
+	}
+	if err == nil {
+		metricMessagesSent.Inc()
+		if o.hooks.Sent != nil && msg.Ref != "" && msg.EditMessageID == 0 {
+			o.hooks.Sent(*msg, sent)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// Остановка, а не ошибка сообщения: попытку не считаем
+		msg.Attempts--
+		slog.Info("⏹️  Отправка прервана, сообщение остается в очереди", "chat_id", msg.ChatID)
+		o.retry(msg, 0, false)
+		return
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.Code == 429:
+			// Telegram сам говорит, сколько ждать. Такие попытки не считаем
+			msg.Attempts--
+			delay := time.Duration(apiErr.RetryAfter) * time.Second
+			if delay <= 0 {
+				delay = time.Second
+			}
+			slog.Info("⏳ Telegram просит подождать", "chat_id", msg.ChatID, "retry_after", delay)
+			o.retry(msg, delay, true)
+			return
+		case apiErr.MigrateToChatID != 0:
+			// Не ошибка сообщения: отправляем его и остальную очередь чата по новому ID
+			msg.Attempts--
+			slog.Info("🔀 Группа стала супергруппой", "chat_id", msg.ChatID, "new_chat_id", apiErr.MigrateToChatID)
+			if o.hooks.Migrated != nil {
+				o.hooks.Migrated(msg.ChatID, apiErr.MigrateToChatID)
+			}
+			o.migrateChat(msg.ChatID, apiErr.MigrateToChatID)
+			msg.ChatID = apiErr.MigrateToChatID
+			o.retry(msg, 0, false)
+			return
+		case thread != 0 && isThreadGone(apiErr) && o.hooks.ThreadGone != nil:
+			msg.Attempts--
+			slog.Warn("⚠️ Тема форума недоступна, пишу в общую", "chat_id", msg.ChatID, "thread_id", thread, "reason", apiErr.Description)
+			o.hooks.ThreadGone(msg.ChatID)
+			o.retry(msg, 0, false)
+			return
+		case isChatGone(apiErr):
+			slog.Info("🚫 Чат недоступен", "chat_id", msg.ChatID, "reason", apiErr.Description)
+			o.deadLetter(msg, err)
+			metricMessagesFailed.Inc("chat_gone")
+			o.dropChat(msg.ChatID)
+			if o.hooks.ChatGone != nil {
+				o.hooks.ChatGone(msg.ChatID)
+			}
+			return
+		case apiErr.Code >= 400 && apiErr.Code < 500:
+			// Ошибка в самом запросе, повтор не поможет
+			slog.Error("❌ Сообщение отклонено", "chat_id", msg.ChatID, "err", err)
+			o.deadLetter(msg, err)
+			metricMessagesFailed.Inc("rejected")
+			return
+		}
+	}
+
+	// Сетевая ошибка или 5xx - повторяем с экспоненциальной задержкой
+	if msg.Attempts >= outboxMaxAttempts {
+		slog.Error("❌ Не удалось отправить сообщение", "chat_id", msg.ChatID, "attempts", msg.Attempts, "err", err)
+		o.deadLetter(msg, err)
+		metricMessagesFailed.Inc("attempts")
+		return
+	}
+
+	delay := time.Duration(1<<uint(msg.Attempts)) * time.Second
+	if delay > outboxMaxBackoff {
+		delay = outboxMaxBackoff
+	}
+	slog.Warn("⚠️ Ошибка отправки, повторю позже", "chat_id", msg.ChatID, "attempt", msg.Attempts, "retry_in", delay, "err", err)
+	o.retry(msg, delay, false)
+}
+
+// retry возвращает сообщение в начало очереди своего чата
+func (o *Outbox) retry(msg *OutgoingMessage, delay time.Duration, global bool) {
+	o.mu.Lock()
+	now := time.Now()
+	msg.notBefore = now.Add(delay)
+	if global {
+		// 429 обычно означает, что бот превысил общий лимит
+		o.globalNext = msg.notBefore
+	}
+	o.pending = append([]*OutgoingMessage{msg}, o.pending...)
+	o.mu.Unlock()
+
+	o.signal()
+}
+
+// dropChat удаляет из очереди все сообщения чата
+func (o *Outbox) dropChat(chatID int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	kept := o.pending[:0]
+	for _, msg := range o.pending {
+		if msg.ChatID != chatID {
+			kept = append(kept, msg)
+		}
+	}
+	o.pending = kept
+	delete(o.chatNext, chatID)
+}
+
+// migrateChat переводит сообщения в очереди на новый ID чата
+func (o *Outbox) migrateChat(from, to int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, msg := range o.pending {
+		if msg.ChatID == from {
+			msg.ChatID = to
+		}
+	}
+	delete(o.chatNext, from)
+}
+
+// deadLetter дописывает недоставленное сообщение в журнал
+func (o *Outbox) deadLetter(msg *OutgoingMessage, cause error) {
+	data, err := json.Marshal(deadLetter{
+		Time:     time.Now(),
+		ChatID:   msg.ChatID,
+		Text:     msg.Text,
+		Attempts: msg.Attempts,
+		Error:    cause.Error(),
+	})
+	if err != nil {
+		return
+	}
+
+	file, err := os.OpenFile(o.deadLetters, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		slog.Warn("⚠️ Не удалось записать недоставленное сообщение", "file", o.deadLetters, "chat_id", msg.ChatID, "err", err)
+		return
+	}
+	defer file.Close()
+
+	file.Write(append(data, '\n'))
+}
+
+// chatInterval возвращает минимальный интервал между сообщениями в чат.
+// У групп и каналов отрицательные ID
+func chatInterval(chatID int64) time.Duration {
+	if chatID < 0 {
+		return outboxGroupInterval
+	}
+	return outboxChatInterval
+}
+
+// isChatGone проверяет, что писать в чат больше нельзя: бот заблокирован,
+// удален из группы или чат не существует
+func isChatGone(err *APIError) bool {
+	if err.Code == 403 {
+		return true
+	}
+	description := strings.ToLower(err.Description)
+	return err.Code == 400 && (strings.Contains(description, "chat not found") ||
+		strings.Contains(description, "user is deactivated"))
+}
+
+// isThreadGone проверяет, что тема форума удалена или закрыта
+func isThreadGone(err *APIError) bool {
+	description := strings.ToLower(err.Description)
+	return err.Code == 400 && (strings.Contains(description, "message thread not found") ||
+		strings.Contains(description, "topic_closed") || strings.Contains(description, "topic_deleted"))
+}
+
+// isNotModified проверяет, что правка не нужна: текст сообщения уже такой
+func isNotModified(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == 400 &&
+		strings.Contains(strings.ToLower(apiErr.Description), "message is not modified")
+}
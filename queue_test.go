@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestOutbox создает очередь поверх заглушки Bot API с журналом
+// недоставленных во временном каталоге
+func newTestOutbox(t *testing.T, api *fakeBotAPI, hooks OutboxHooks) (*Outbox, string) {
+	deadLetters := filepath.Join(t.TempDir(), DeadLettersFile)
+	return NewOutbox(api.client(), deadLetters, hooks), deadLetters
+}
+
+// drain досылает очередь и проверяет, что она опустела
+func drain(t *testing.T, outbox *Outbox) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if left := outbox.Drain(ctx); left != 0 {
+		t.Fatalf("в очереди остались сообщения: %d", left)
+	}
+}
+
+// sentTexts возвращает тексты доставленных сообщений по чатам в порядке отправки
+func sentTexts(api *fakeBotAPI) map[string][]string {
+	texts := make(map[string][]string)
+	for _, call := range api.callsTo("sendMessage") {
+		texts[call.Params.Get("chat_id")] = append(texts[call.Params.Get("chat_id")], call.Params.Get("text"))
+	}
+	return texts
+}
+
+// readDeadLetters читает журнал недоставленных сообщений
+func readDeadLetters(t *testing.T, path string) []deadLetter {
+	t.Helper()
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var letters []deadLetter
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var letter deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func TestOutboxKeepsChatOrderOnRetry(t *testing.T) {
+	api := newFakeBotAPI(t)
+	failed := false
+	api.setRespond(func(call fakeCall) *fakeReply {
+		// Первое сообщение чата 1 один раз падает с 5xx
+		if call.Params.Get("text") == "1a" && !failed {
+			failed = true
+			return apiFail(502, "Bad Gateway", nil)
+		}
+		return nil
+	})
+	outbox, _ := newTestOutbox(t, api, OutboxHooks{})
+
+	for _, msg := range []OutgoingMessage{{ChatID: 1, Text: "1a"}, {ChatID: 1, Text: "1b"}, {ChatID: 2, Text: "2a"}} {
+		if err := outbox.Push(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drain(t, outbox)
+
+	got := sentTexts(api)
+	// "1a" уходит дважды: неудачная попытка и повтор, "1b" его не обгоняет
+	if want := []string{"1a", "1a", "1b"}; !reflect.DeepEqual(got["1"], want) {
+		t.Errorf("чат 1: %v, ожидалось %v", got["1"], want)
+	}
+	if want := []string{"2a"}; !reflect.DeepEqual(got["2"], want) {
+		t.Errorf("чат 2: %v, ожидалось %v", got["2"], want)
+	}
+
+	// Повтор одного чата не задерживает другой
+	order := api.callsTo("sendMessage")
+	if order[1].Params.Get("text") != "2a" {
+		t.Errorf("чат 2 ждал повтора в чате 1: второй запрос %q", order[1].Params.Get("text"))
+	}
+}
+
+func TestOutboxRetryAfter(t *testing.T) {
+	api := newFakeBotAPI(t)
+	limited := false
+	api.setRespond(func(call fakeCall) *fakeReply {
+		if !limited {
+			limited = true
+			return apiFail(429, "Too Many Requests: retry after 1", map[string]interface{}{"retry_after": 1})
+		}
+		return nil
+	})
+	outbox, deadLetters := newTestOutbox(t, api, OutboxHooks{})
+
+	started := time.Now()
+	outbox.Enqueue(1, "напоминание")
+	outbox.Enqueue(2, "другой чат")
+	drain(t, outbox)
+
+	calls := api.callsTo("sendMessage")
+	if len(calls) != 3 {
+		t.Fatalf("запросов sendMessage: %d, ожидалось 3", len(calls))
+	}
+	// retry_after останавливает всю очередь, а не только чат
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Errorf("повтор через %s, раньше retry_after", elapsed)
+	}
+	if letters := readDeadLetters(t, deadLetters); len(letters) != 0 {
+		t.Errorf("429 попал в недоставленные: %+v", letters)
+	}
+}
+
+func TestOutboxMigratesChat(t *testing.T) {
+	api := newFakeBotAPI(t)
+	api.setRespond(func(call fakeCall) *fakeReply {
+		if call.Params.Get("chat_id") == "-200" {
+			return apiFail(400, "Bad Request: group chat was upgraded to a supergroup chat",
+				map[string]interface{}{"migrate_to_chat_id": -100200})
+		}
+		return nil
+	})
+
+	var migrated [][2]int64
+	outbox, _ := newTestOutbox(t, api, OutboxHooks{
+		Migrated: func(from, to int64) { migrated = append(migrated, [2]int64{from, to}) },
+	})
+	outbox.Enqueue(-200, "первое")
+	outbox.Enqueue(-200, "второе")
+	drain(t, outbox)
+
+	if want := [][2]int64{{-200, -100200}}; !reflect.DeepEqual(migrated, want) {
+		t.Errorf("хук Migrated: %v, ожидалось %v", migrated, want)
+	}
+	got := sentTexts(api)
+	if want := []string{"первое", "второе"}; !reflect.DeepEqual(got["-100200"], want) {
+		t.Errorf("в новый чат: %v, ожидалось %v", got["-100200"], want)
+	}
+	if len(got["-200"]) != 1 {
+		t.Errorf("в старый чат ушло запросов: %d, ожидался 1", len(got["-200"]))
+	}
+}
+
+func TestOutboxDeadLetters(t *testing.T) {
+	api := newFakeBotAPI(t)
+	api.setRespond(func(call fakeCall) *fakeReply {
+		switch call.Params.Get("chat_id") {
+		case "77":
+			return apiFail(403, "Forbidden: bot was blocked by the user", nil)
+		case "88":
+			return apiFail(400, "Bad Request: can't parse entities", nil)
+		}
+		return nil
+	})
+
+	var gone []int64
+	outbox, deadLetters := newTestOutbox(t, api, OutboxHooks{
+		ChatGone: func(chatID int64) { gone = append(gone, chatID) },
+	})
+	outbox.Enqueue(77, "заблокировал")
+	outbox.Enqueue(77, "не отправится")
+	outbox.Enqueue(88, "<b>сломанная разметка")
+	outbox.Enqueue(1, "дойдет")
+	drain(t, outbox)
+
+	if want := []int64{77}; !reflect.DeepEqual(gone, want) {
+		t.Errorf("хук ChatGone: %v, ожидалось %v", gone, want)
+	}
+	// Остальные сообщения заблокировавшего чата выбрасываются без запросов
+	if got := sentTexts(api); len(got["77"]) != 1 || len(got["88"]) != 1 || len(got["1"]) != 1 {
+		t.Errorf("запросы по чатам: %v", got)
+	}
+
+	letters := readDeadLetters(t, deadLetters)
+	if len(letters) != 2 {
+		t.Fatalf("недоставленных: %d, ожидалось 2: %+v", len(letters), letters)
+	}
+	chats := map[int64]bool{letters[0].ChatID: true, letters[1].ChatID: true}
+	if !chats[77] || !chats[88] {
+		t.Errorf("недоставленные не из тех чатов: %+v", letters)
+	}
+	for _, letter := range letters {
+		if letter.Attempts != 1 || letter.Error == "" {
+			t.Errorf("запись журнала: %+v", letter)
+		}
+	}
+}
+
+func TestOutboxSentHook(t *testing.T) {
+	api := newFakeBotAPI(t)
+	var mu sync.Mutex
+	var sent []string
+	outbox, _ := newTestOutbox(t, api, OutboxHooks{
+		Sent: func(msg OutgoingMessage, message Message) {
+			mu.Lock()
+			defer mu.Unlock()
+			sent = append(sent, msg.Ref)
+			if message.MessageID == 0 {
+				t.Errorf("хук Sent без message_id: %+v", message)
+			}
+		},
+	})
+	outbox.Push(OutgoingMessage{ChatID: 1, Text: "напоминание", Ref: "lesson-1"})
+	outbox.Push(OutgoingMessage{ChatID: 2, Text: "без метки"})
+	outbox.Push(OutgoingMessage{ChatID: 3, Text: "правка", Ref: "lesson-1", EditMessageID: 5})
+	drain(t, outbox)
+
+	if want := []string{"lesson-1"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("хук Sent: %v, ожидалось %v", sent, want)
+	}
+	if edits := api.callsTo("editMessageText"); len(edits) != 1 || edits[0].Params.Get("message_id") != "5" {
+		t.Errorf("правки: %+v", edits)
+	}
+}
+
+func TestOutboxSpool(t *testing.T) {
+	api := newFakeBotAPI(t)
+	spool := filepath.Join(t.TempDir(), OutboxFile)
+
+	outbox, _ := newTestOutbox(t, api, OutboxHooks{})
+	outbox.Enqueue(1, "первое")
+	outbox.Push(OutgoingMessage{ChatID: 2, Text: "правка", EditMessageID: 9, Ref: "lesson"})
+	if err := outbox.Save(spool); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	// Устаревшее за время остановки сообщение не отправляется
+	data, err := os.ReadFile(spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []OutgoingMessage
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	saved = append(saved, OutgoingMessage{ChatID: 3, Text: "старое", Queued: time.Now().Add(-time.Hour)})
+	data, _ = json.Marshal(saved)
+	if err := os.WriteFile(spool, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, deadLetters := newTestOutbox(t, api, OutboxHooks{})
+	n, err := restored.Load(spool)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Load вернул %d сообщений, ожидалось 2", n)
+	}
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("файл очереди не удален после загрузки: %v", err)
+	}
+
+	pending := restored.Snapshot()
+	if pending[0].Text != "первое" || pending[1].EditMessageID != 9 || pending[1].Ref != "lesson" {
+		t.Errorf("очередь после загрузки: %+v", pending)
+	}
+	if letters := readDeadLetters(t, deadLetters); len(letters) != 1 || letters[0].ChatID != 3 {
+		t.Errorf("устаревшее сообщение не в журнале недоставленных: %+v", letters)
+	}
+
+	// Пустая очередь удаляет файл
+	drain(t, restored)
+	os.WriteFile(spool, []byte("[]"), 0644)
+	if err := restored.Save(spool); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("пустая очередь оставила файл: %v", err)
+	}
+}
+
+func TestOutboxDrainStopsAtDeadline(t *testing.T) {
+	api := newFakeBotAPI(t)
+	outbox, _ := newTestOutbox(t, api, OutboxHooks{})
+	for _, text := range []string{"1", "2", "3"} {
+		outbox.Enqueue(1, text)
+	}
+
+	// В личный чат не чаще раза в секунду: за полсекунды уходит только первое
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if left := outbox.Drain(ctx); left != 2 {
+		t.Errorf("Drain оставил %d сообщений, ожидалось 2", left)
+	}
+	if got := sentTexts(api)["1"]; !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("отправлено: %v", got)
+	}
+}
+
+func TestOutboxDrainCancelsInFlight(t *testing.T) {
+	// Telegram не отвечает: запрос висит, пока его не отменят
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(hung.Close)
+	t.Cleanup(func() { close(release) })
+
+	deadLetters := filepath.Join(t.TempDir(), DeadLettersFile)
+	outbox := NewOutbox(NewTelegramClient(hung.URL, testToken, nil), deadLetters, OutboxHooks{})
+	outbox.Enqueue(1, "напоминание")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	if left := outbox.Drain(ctx); left != 1 {
+		t.Fatalf("Drain оставил %d сообщений, ожидалось 1", left)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Drain ждал зависший запрос %s", elapsed)
+	}
+
+	// Прерванное сообщение не потеряно и не считается неудачной попыткой
+	pending := outbox.Snapshot()
+	if len(pending) != 1 || pending[0].Text != "напоминание" || pending[0].Attempts != 0 {
+		t.Errorf("очередь после Drain: %+v", pending)
+	}
+	if letters := readDeadLetters(t, deadLetters); len(letters) != 0 {
+		t.Errorf("прерванное сообщение попало в недоставленные: %+v", letters)
+	}
+
+	spool := filepath.Join(t.TempDir(), OutboxFile)
+	if err := outbox.Save(spool); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	restored, _ := newTestOutbox(t, newFakeBotAPI(t), OutboxHooks{})
+	if n, err := restored.Load(spool); err != nil || n != 1 {
+		t.Errorf("Load: %d сообщений, %v", n, err)
+	}
+}
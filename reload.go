@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"msuparser/parser"
+)
+
+// fileWatcher замечает изменение файла по времени изменения и размеру.
+// Опрос раз в минуту проще inotify и переживает замену файла через rename
+type fileWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func newFileWatcher(path string) *fileWatcher {
+	w := &fileWatcher{path: path}
+	w.Reset()
+	return w
+}
+
+// Changed проверяет, изменился ли файл с прошлого вызова
+func (w *fileWatcher) Changed() bool {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		// Пропавший файл не перечитываем: бот продолжает работать с тем, что загрузил
+		return false
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false
+	}
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+	return true
+}
+
+// Reset запоминает текущее состояние файла, например после записи самим ботом
+func (w *fileWatcher) Reset() {
+	if info, err := os.Stat(w.path); err == nil {
+		w.modTime = info.ModTime()
+		w.size = info.Size()
+	}
+}
+
+// reloadChangedFiles перечитывает schedule.json, config.json и
+// fetch_status.json, если их изменили снаружи (например, msuparser-update.service)
+func (bot *TimetableBot) reloadChangedFiles() {
+	bot.updateMu.Lock()
+	defer bot.updateMu.Unlock()
+
+	if bot.scheduleWatcher != nil && bot.scheduleWatcher.Changed() {
+		bot.reloadSchedule()
+	}
+	if bot.configWatcher != nil && bot.configWatcher.Changed() {
+		bot.reloadConfig()
+	}
+	if bot.fetchStatusWatcher != nil && bot.fetchStatusWatcher.Changed() {
+		bot.reloadFetchStatus()
+	}
+}
+
+// reloadSchedule подменяет расписание на версию из файла, если она прошла проверку
+func (bot *TimetableBot) reloadSchedule() {
+	schedule, err := ReadSchedule(ScheduleFile)
+	if err == nil {
+		err = parser.Validate(schedule.Lessons)
+	}
+	if err != nil {
+		slog.Warn("⚠️ Файл расписания изменился, но не загружен, оставляю текущее расписание", "file", ScheduleFile, "err", err)
+		bot.errors.Record(SubsystemReload, fmt.Errorf("%s: %w", ScheduleFile, err))
+		return
+	}
+
+	diff := DiffSchedules(bot.Schedule(), schedule.Lessons)
+	bot.setSchedule(schedule)
+	slog.Info("🔄 Расписание перечитано", "file", ScheduleFile, "lessons", len(schedule.Lessons),
+		"added", len(diff.Added), "removed", len(diff.Removed), "changed", len(diff.Changed))
+	logScheduleDiff(diff)
+	bot.reviseReminders(diff, schedule.Lessons)
+
+	bot.rebuildPendingNotifications()
+	bot.goBackground(bot.syncCalDAV)
+}
+
+// rebuildPendingNotifications забывает отметки об отправке для пар, которых
+// больше нет в расписании, и пишет в лог, сколько напоминаний впереди.
+// Сами напоминания каждую минуту считаются заново по текущему расписанию
+func (bot *TimetableBot) rebuildPendingNotifications() {
+	current := make(map[string]bool)
+	for _, lesson := range bot.Schedule() {
+		current[fmt.Sprintf("%s_%s_%s", lesson.Date, lesson.LessonNumber, lesson.Subject)] = true
+	}
+
+	removed := bot.sentNotifications.Prune(func(key string) bool {
+		// Ключ: <чат>_<дата>_<номер пары>_<предмет>
+		parts := strings.SplitN(key, "_", 2)
+		return len(parts) == 2 && current[parts[1]]
+	})
+
+	pending := 0
+	for _, chat := range bot.subscribers.All() {
+		pending += len(bot.GetUpcomingLessons(chat))
+	}
+	slog.Info("🔔 Напоминания пересчитаны", "pending", pending, "reset_sent", removed)
+}
+
+// logScheduleDiff пишет в журнал по событию на каждую добавленную, удаленную и измененную пару
+func logScheduleDiff(diff ScheduleDiff) {
+	for _, lesson := range diff.Added {
+		slog.Info("➕ Пара добавлена", "lesson_id", lesson.ID(), "lesson", describeLesson(&lesson))
+	}
+	for _, lesson := range diff.Removed {
+		slog.Info("➖ Пара удалена", "lesson_id", lesson.ID(), "lesson", describeLesson(&lesson))
+	}
+	for _, change := range diff.Changed {
+		slog.Info("✏️ Пара изменена", "lesson_id", change.New.ID(), "lesson", describeLesson(&change.New),
+			"changes", strings.Join(changedFields(&change.Old, &change.New), "; "))
+	}
+}
+
+// Параметры, которые нельзя поменять без перезапуска
+var restartOnlyConfigKeys = map[string]bool{
+	"BOT_TOKEN":        true,
+	"BOT_TOKEN_FILE":   true,
+	"USER_ID":          true,
+	"TELEGRAM_API_URL": true,
+	"UPDATES_MODE":     true,
+	"WEBHOOK_URL":      true,
+	"WEBHOOK_LISTEN":   true,
+	"METRICS_LISTEN":   true,
+	"WEBHOOK_SECRET":   true,
+	"WEBHOOK_CERT":     true,
+	"WEBHOOK_KEY":      true,
+	"LOG_FORMAT":       true,
+	"HTTP_LISTEN":      true,
+}
+
+// Значения этих параметров не пишем в лог
+var secretConfigKeys = map[string]bool{
+	"BOT_TOKEN":       true,
+	"WEBHOOK_SECRET":  true,
+	"CALDAV_PASSWORD": true,
+}
+
+// reloadConfig применяет изменения файла конфига. Неверный конфиг не
+// применяется целиком, параметры запуска требуют перезапуска
+func (bot *TimetableBot) reloadConfig() {
+	path, _ := bot.configSource.File()
+	running := bot.runtime().config
+	config, err := LoadConfig(bot.configSource)
+	if err == nil {
+		// Иначе Validate сгенерирует новый случайный секрет, и это будет выглядеть как правка
+		if config.WebhookSecret == "" {
+			config.WebhookSecret = running.WebhookSecret
+		}
+		err = config.ValidateBot()
+	}
+	if err != nil {
+		slog.Warn("⚠️ Конфиг изменился, но не применен", "file", path, "err", err)
+		bot.errors.Record(SubsystemReload, fmt.Errorf("%s: %w", path, err))
+		return
+	}
+
+	changes := configChanges(running, config)
+	if len(changes) == 0 {
+		return
+	}
+
+	var needRestart []string
+	for _, change := range changes {
+		slog.Info("🔧 Параметр конфига изменен", "file", path, "change", change.String())
+		if restartOnlyConfigKeys[change.Key] {
+			needRestart = append(needRestart, change.Key)
+		}
+	}
+	if len(needRestart) > 0 {
+		slog.Warn("⚠️ Вступят в силу после перезапуска", "keys", strings.Join(needRestart, ", "))
+	}
+
+	// Параметры запуска оставляем прежними, чтобы снимок описывал то, что реально работает
+	for _, key := range needRestart {
+		copyConfigKey(&config, &running, key)
+	}
+	bot.applyConfig(config)
+	bot.rebuildPendingNotifications()
+}
+
+// configChange изменение одного параметра конфига
+type configChange struct {
+	Key      string
+	Old, New interface{}
+}
+
+func (c configChange) String() string {
+	if secretConfigKeys[c.Key] {
+		return c.Key + " изменен"
+	}
+	old, _ := json.Marshal(c.Old)
+	new, _ := json.Marshal(c.New)
+	return fmt.Sprintf("%s: %s -> %s", c.Key, old, new)
+}
+
+// configChanges сравнивает конфиги по ключам JSON
+func configChanges(old, new Config) []configChange {
+	oldValue := reflect.ValueOf(old)
+	newValue := reflect.ValueOf(new)
+	configType := oldValue.Type()
+
+	var changes []configChange
+	for i := 0; i < configType.NumField(); i++ {
+		key := configFieldKey(configType.Field(i))
+		if key == "" {
+			continue
+		}
+		before := oldValue.Field(i).Interface()
+		after := newValue.Field(i).Interface()
+		if !reflect.DeepEqual(before, after) {
+			changes = append(changes, configChange{Key: key, Old: before, New: after})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// copyConfigKey переносит значение параметра key из from в to
+func copyConfigKey(to, from *Config, key string) {
+	toField, ok := configField(to, key)
+	if !ok {
+		return
+	}
+	fromField, _ := configField(from, key)
+	toField.Set(fromField)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"msuparser/parser"
+)
+
+const RemindersFile = "reminders.json" // Отправленные напоминания, которые можно исправить
+
+// Напоминание уходит не раньше чем за 3 часа до пары, так что через сутки
+// исправлять уже нечего
+const reminderKeep = 24 * time.Hour
+
+// sentReminder отправленное напоминание о паре
+type sentReminder struct {
+	ChatID    int64     `json:"chat_id"`
+	LessonID  string    `json:"lesson_id"`
+	MessageID int       `json:"message_id"`
+	Sent      time.Time `json:"sent"`
+}
+
+// ReminderStore помнит message_id отправленных напоминаний, чтобы при
+// изменении пары исправить сообщение, а не присылать новое
+type ReminderStore struct {
+	mu        sync.Mutex
+	filename  string
+	reminders map[string]sentReminder // Ключ: чат + пара
+}
+
+// NewReminderStore создает хранилище поверх файла filename
+func NewReminderStore(filename string) *ReminderStore {
+	return &ReminderStore{filename: filename, reminders: make(map[string]sentReminder)}
+}
+
+func reminderKey(chatID int64, lessonID string) string {
+	return fmt.Sprintf("%d_%s", chatID, lessonID)
+}
+
+// Load читает напоминания из файла. Отсутствующий файл - не ошибка
+func (s *ReminderStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var list []sentReminder
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("ошибка парсинга %s: %w", s.filename, err)
+	}
+
+	s.reminders = make(map[string]sentReminder, len(list))
+	for _, r := range list {
+		s.reminders[reminderKey(r.ChatID, r.LessonID)] = r
+	}
+	return nil
+}
+
+// save сохраняет напоминания на диск. Вызывается под s.mu
+func (s *ReminderStore) save() error {
+	list := make([]sentReminder, 0, len(s.reminders))
+	for _, r := range s.reminders {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Sent.Before(list[j].Sent) })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filename, data, 0644)
+}
+
+// Record запоминает отправленное напоминание и забывает старые
+func (s *ReminderStore) Record(r sentReminder) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, old := range s.reminders {
+		if r.Sent.Sub(old.Sent) > reminderKeep {
+			delete(s.reminders, key)
+		}
+	}
+	s.reminders[reminderKey(r.ChatID, r.LessonID)] = r
+	return s.save()
+}
+
+// ForLesson возвращает напоминания о паре во все чаты
+func (s *ReminderStore) ForLesson(lessonID string) []sentReminder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []sentReminder
+	for _, r := range s.reminders {
+		if r.LessonID == lessonID {
+			found = append(found, r)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ChatID < found[j].ChatID })
+	return found
+}
+
+// handleReminderSent запоминает message_id доставленного напоминания
+func (bot *TimetableBot) handleReminderSent(msg OutgoingMessage, sent Message) {
+	err := bot.reminders.Record(sentReminder{
+		ChatID:    msg.ChatID,
+		LessonID:  msg.Ref,
+		MessageID: sent.MessageID,
+		Sent:      time.Now(),
+	})
+	if err != nil {
+		slog.Warn("⚠️ Не удалось сохранить отправленное напоминание", "file", RemindersFile, "chat_id", msg.ChatID, "err", err)
+	}
+}
+
+// reviseReminders исправляет уже отправленные напоминания о парах, которые
+// изменились или пропали из расписания. Сообщение редактируется с пометкой
+// об изменении, а чатам с /changes on приходит еще и короткий ответ на него.
+// lessons - новое расписание: по нему видно, какие пары просто вышли из окна загрузки
+func (bot *TimetableBot) reviseReminders(diff ScheduleDiff, lessons []parser.Lesson) {
+	for _, change := range diff.Changed {
+		lines := lessonChangeLines(&change.Old, &change.New)
+		if len(lines) == 0 {
+			continue
+		}
+		text := "✏️ <b>Изменено:</b>\n" + strings.Join(lines, "\n") + "\n\n" + bot.FormatNotification(&change.New)
+		ping := fmt.Sprintf("✏️ Изменилась пара %s:\n%s", html.EscapeString(change.New.Subject), strings.Join(lines, "\n"))
+		bot.reviseReminder(change.Old.ID(), text, ping)
+	}
+
+	firstDate := firstLessonDate(lessons)
+	now := bot.now()
+	for _, lesson := range diff.Removed {
+		// Сайт отдает расписание начиная с сегодняшнего дня, так что вчерашние
+		// и уже начавшиеся пары пропадают из него без всякой отмены
+		if firstDate == "" || dateKey(lesson.Date) < dateKey(firstDate) {
+			continue
+		}
+		if start, err := ParseTime(lesson.Date, lesson.TimeStart, now.Location()); err != nil || !start.After(now) {
+			continue
+		}
+
+		status := "отменена или перенесена"
+		if moved, ok := movedLesson(&lesson, diff.Added); ok {
+			status = fmt.Sprintf("перенесена на %s %s-%s", moved.Date, moved.TimeStart, moved.TimeEnd)
+		}
+		text := fmt.Sprintf("❌ <b>Пара %s</b>\n\n<s>%s</s>", status, html.EscapeString(describeLesson(&lesson)))
+		ping := fmt.Sprintf("❌ Пара %s в %s %s", html.EscapeString(lesson.Subject), lesson.TimeStart, status)
+		bot.reviseReminder(lesson.ID(), text, ping)
+	}
+}
+
+// reviseReminder ставит в очередь правку напоминаний о паре lessonID и ответы на них
+func (bot *TimetableBot) reviseReminder(lessonID, text, ping string) {
+	now := bot.now()
+	for _, r := range bot.reminders.ForLesson(lessonID) {
+		if err := bot.outbox.Push(OutgoingMessage{ChatID: r.ChatID, Text: text, EditMessageID: r.MessageID}); err != nil {
+			continue
+		}
+		slog.Info("✏️ Правка напоминания в очереди", "chat_id", r.ChatID, "lesson_id", lessonID, "message_id", r.MessageID)
+
+		settings, ok := bot.subscribers.Get(r.ChatID)
+		if !ok || !settings.ChangeAlerts || settings.IsQuiet(now) {
+			continue
+		}
+		if bot.outbox.Push(OutgoingMessage{ChatID: r.ChatID, Text: ping, ReplyTo: r.MessageID}) == nil {
+			metricNotificationsQueued.Inc("change")
+		}
+	}
+}
+
+// lessonChangeLines перечисляет для пользователя, что поменялось в паре
+func lessonChangeLines(old, new *parser.Lesson) []string {
+	var lines []string
+	add := func(name, before, after string) {
+		if before == after {
+			return
+		}
+		if before == "" {
+			before = "—"
+		}
+		if after == "" {
+			after = "—"
+		}
+		lines = append(lines, fmt.Sprintf("%s: <s>%s</s> → <b>%s</b>", name, html.EscapeString(before), html.EscapeString(after)))
+	}
+	add("🚪 Аудитория", old.Room, new.Room)
+	add("👨‍🏫 Преподаватель", old.Teacher, new.Teacher)
+	add("🕐 Конец", old.TimeEnd, new.TimeEnd)
+	add("🔢 Номер пары", old.LessonNumber, new.LessonNumber)
+	return lines
+}
+
+// movedLesson ищет среди добавленных пар ту же пару в тот же день, но в другое
+// время: перенос по времени выглядит в разнице как удаление и добавление
+func movedLesson(removed *parser.Lesson, added []parser.Lesson) (parser.Lesson, bool) {
+	for _, lesson := range added {
+		if lesson.Group == removed.Group && lesson.Date == removed.Date && lesson.Subject == removed.Subject {
+			return lesson, true
+		}
+	}
+	return parser.Lesson{}, false
+}
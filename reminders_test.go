@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"msuparser/parser"
+)
+
+func TestReviseReminders(t *testing.T) {
+	lesson := testLesson("20.10.2026", "13:00", "Право [Семинар]", "101")
+	moved := lesson
+	moved.Room = "202"
+
+	markup := testLesson("20.10.2026", "13:00", "R&D <br> практикум [Семинар]", "101")
+	markupMoved := markup
+	markupMoved.Room = "<202>"
+
+	later := testLesson("20.10.2026", "16:30", "Экономика [Лекция]", "303")
+	yesterday := testLesson("19.10.2026", "13:00", "История [Лекция]", "404")
+
+	tests := []struct {
+		name     string
+		old, new []parser.Lesson
+		settings func(*ChatSettings) // nil - настройки по умолчанию
+		edit     []string            // Подстроки правки напоминания, nil - правки нет
+		ping     string              // Подстрока ответа на напоминание, "" - ответа нет
+	}{
+		{
+			name: "аудитория изменилась",
+			old:  []parser.Lesson{lesson},
+			new:  []parser.Lesson{moved},
+			edit: []string{"<s>101</s> → <b>202</b>", "<b>Аудитория:</b> 202"},
+			ping: "Изменилась пара Право [Семинар]",
+		},
+		{
+			name: "разметка в названии и аудитории экранируется",
+			old:  []parser.Lesson{markup},
+			new:  []parser.Lesson{markupMoved},
+			edit: []string{"R&amp;D &lt;br&gt; практикум", "&lt;202&gt;"},
+			ping: "R&amp;D &lt;br&gt; практикум",
+		},
+		{
+			name:     "без ответа при /changes off",
+			old:      []parser.Lesson{lesson},
+			new:      []parser.Lesson{moved},
+			settings: func(s *ChatSettings) { s.ChangeAlerts = false },
+			edit:     []string{"Изменено"},
+		},
+		{
+			name: "пара отменена",
+			old:  []parser.Lesson{lesson, later},
+			new:  []parser.Lesson{later},
+			edit: []string{"отменена или перенесена", "<s>"},
+			ping: "Право [Семинар] в 13:00 отменена",
+		},
+		{
+			name: "прошедшая пара вышла из окна загрузки",
+			old:  []parser.Lesson{yesterday, later},
+			new:  []parser.Lesson{later},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fixedClock{now: at(t, "20.10.2026 12:30:00")}
+			bot := newTestBot(t, newFakeBotAPI(t), clock)
+			if _, err := bot.subscribers.Subscribe(testChatID); err != nil {
+				t.Fatal(err)
+			}
+			if tt.settings != nil {
+				if _, err := bot.subscribers.Update(testChatID, tt.settings); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := bot.reminders.Record(sentReminder{ChatID: testChatID, LessonID: tt.old[0].ID(), MessageID: 55, Sent: time.Now()})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bot.reviseReminders(DiffSchedules(tt.old, tt.new), tt.new)
+
+			var edit, ping *OutgoingMessage
+			for _, msg := range bot.outbox.Snapshot() {
+				switch {
+				case msg.EditMessageID == 55 && edit == nil:
+					edit = &msg
+				case msg.ReplyTo == 55 && ping == nil:
+					ping = &msg
+				default:
+					t.Errorf("лишнее сообщение: %+v", msg)
+				}
+			}
+
+			if tt.edit == nil && edit != nil {
+				t.Errorf("лишняя правка: %q", edit.Text)
+			}
+			if tt.edit != nil {
+				if edit == nil {
+					t.Fatal("напоминание не исправлено")
+				}
+				for _, want := range tt.edit {
+					if !strings.Contains(edit.Text, want) {
+						t.Errorf("правка %q, ожидалось с %q", edit.Text, want)
+					}
+				}
+			}
+			if tt.ping == "" && ping != nil {
+				t.Errorf("лишний ответ: %q", ping.Text)
+			}
+			if tt.ping != "" && (ping == nil || !strings.Contains(ping.Text, tt.ping)) {
+				t.Errorf("ответ %+v, ожидался с %q", ping, tt.ping)
+			}
+		})
+	}
+}
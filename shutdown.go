@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ShutdownTimeout сколько остановка ждет прием обновлений, HTTP серверы и
+// отправку очереди. Должен быть меньше TimeoutStopSec в юните systemd
+const ShutdownTimeout = 20 * time.Second
+
+// goBackground запускает f в горутине, которую shutdown дождется перед выходом
+func (bot *TimetableBot) goBackground(f func()) {
+	bot.background.Add(1)
+	go func() {
+		defer bot.background.Done()
+		f()
+	}()
+}
+
+// shutdown вызывается после отмены корневого контекста. Планировщик уже
+// стоит; ждем, пока прием обновлений, HTTP серверы и синхронизация CalDAV
+// закончат начатое, затем досылаем очередь сообщений. На все вместе
+// дается ShutdownTimeout, неотправленное сохраняется в outbox.json
+func (bot *TimetableBot) shutdown() {
+	slog.Info("⏹️  Останавливаю бота")
+	sdNotify("STOPPING=1")
+
+	deadline, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	if !waitContext(deadline, &bot.background) {
+		slog.Warn("⚠️ Фоновые задачи не завершились за отведенное время", "timeout", ShutdownTimeout)
+	}
+
+	if pending := bot.outbox.Pending(); pending > 0 {
+		slog.Info("📤 Досылаю очередь", "messages", pending)
+	}
+	left := bot.outbox.Drain(deadline)
+	if err := bot.outbox.Save(OutboxFile); err != nil {
+		slog.Error("❌ Не удалось сохранить очередь сообщений", "file", OutboxFile, "err", err)
+	} else if left > 0 {
+		slog.Warn("💾 Не все сообщения отправлены, остаток сохранен", "messages", left, "file", OutboxFile)
+	}
+
+	slog.Info("⏹️  Бот остановлен")
+}
+
+// waitContext ждет wg, но не дольше ctx. Возвращает false, если не дождался
+func waitContext(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// serveHTTP обслуживает запросы через listen, пока не отменен ctx. Затем
+// сервер перестает принимать соединения, а начатым запросам дается
+// ShutdownTimeout на завершение
+func serveHTTP(ctx context.Context, server *http.Server, listen func() error) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- listen()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
+}
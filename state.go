@@ -0,0 +1,120 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"msuparser/parser"
+)
+
+// runtimeConfig параметры бота, которые можно менять на ходу. Как и
+// расписание, снимок не меняется после публикации: applyConfig собирает
+// новый и подменяет его целиком, поэтому команды, HTTP обработчики и
+// планировщик читают его из своих горутин без гонок
+type runtimeConfig struct {
+	config       Config
+	location     *time.Location // Часовой пояс расписания из TIMEZONE
+	parserConfig parser.ParserConfig
+	adminChatID  int64       // Куда слать предупреждения об обновлении расписания, 0 - только в лог
+	caldav       *CalDAVSync // nil, если CalDAV не настроен
+	history      *History
+}
+
+// newRuntimeConfig собирает снимок из конфига. CalDAV синхронизация
+// переносится из prev, если ее параметры не менялись: в ней своя блокировка
+func newRuntimeConfig(config Config, prev *runtimeConfig, clock Clock) *runtimeConfig {
+	rt := &runtimeConfig{
+		config:       config,
+		location:     config.Location(),
+		parserConfig: config.ParserConfig(),
+		adminChatID:  config.AdminChat(),
+	}
+	rt.parserConfig.Now = clock.Now
+	rt.parserConfig.OnStep = observeFetchStep
+
+	historyDir := config.HistoryDir
+	if historyDir == "" {
+		historyDir = DefaultHistoryDir
+	}
+	rt.history = NewHistory(historyDir, rt.location)
+
+	old := Config{}
+	if prev != nil {
+		old = prev.config
+		rt.caldav = prev.caldav
+	}
+	caldavChanged := prev == nil ||
+		config.CalDAVURL != old.CalDAVURL ||
+		config.CalDAVUsername != old.CalDAVUsername ||
+		config.CalDAVPassword != old.CalDAVPassword ||
+		config.Timezone != old.Timezone
+	if caldavChanged {
+		rt.caldav = nil
+		if config.CalDAVURL != "" {
+			rt.caldav = NewCalDAVSync(config.CalDAVURL, config.CalDAVUsername, config.CalDAVPassword, CalDAVStateFile, rt.location)
+		}
+	}
+	return rt
+}
+
+// runtime возвращает текущий снимок параметров
+func (bot *TimetableBot) runtime() *runtimeConfig {
+	bot.runtimeMu.RLock()
+	defer bot.runtimeMu.RUnlock()
+	return bot.runtimeCfg
+}
+
+// applyConfig применяет параметры конфига, которые можно менять на ходу
+func (bot *TimetableBot) applyConfig(config Config) {
+	bot.runtimeMu.Lock()
+	bot.runtimeCfg = newRuntimeConfig(config, bot.runtimeCfg, bot.clock)
+	bot.runtimeMu.Unlock()
+
+	bot.subscribers.SetDefaultLeadMinutes(config.NotificationMinutes)
+	setLogLevel(config.LogLevel)
+
+	staleAfter := DefaultStaleAfter
+	if config.StaleAfterHours > 0 {
+		staleAfter = time.Duration(config.StaleAfterHours) * time.Hour
+	}
+	bot.fetchHealth.SetStaleAfter(staleAfter)
+}
+
+// sentSet отметки об отправленных напоминаниях
+type sentSet struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func newSentSet() *sentSet {
+	return &sentSet{keys: make(map[string]bool)}
+}
+
+// Has проверяет, было ли уже отправлено напоминание с ключом key
+func (s *sentSet) Has(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[key]
+}
+
+// Mark отмечает напоминание отправленным
+func (s *sentSet) Mark(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = true
+}
+
+// Prune забывает отметки, для которых keep вернул false, и возвращает их число
+func (s *sentSet) Prune(keep func(key string) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for key := range s.keys {
+		if !keep(key) {
+			delete(s.keys, key)
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"msuparser/parser"
+)
+
+// ChatSettings хранит персональные настройки уведомлений одного чата
+type ChatSettings struct {
+	ChatID            int64          `json:"chat_id"`
+	LeadMinutes       int            `json:"lead_minutes"`
+	LeadCustom        bool           `json:"lead_custom,omitempty"` // Время напоминания задано через /lead
+	MorningDigest     bool           `json:"morning_digest"`
+	DistanceReminders bool           `json:"distance_reminders"`
+	QuietStart        string         `json:"quiet_start,omitempty"` // "23:00"
+	QuietEnd          string         `json:"quiet_end,omitempty"`   // "08:00"
+	Filters           []LessonFilter `json:"filters,omitempty"`     // Скрытые предметы, преподаватели, подгруппы
+	FeedToken         string         `json:"feed_token,omitempty"`  // Секрет личной ссылки на календарь
+	ThreadID          int            `json:"thread_id,omitempty"`   // Тема форума в группе, 0 - общая
+	ChangeAlerts      bool           `json:"change_alerts"`         // Сообщать, если пара из отправленного напоминания изменилась
+}
+
+// DefaultChatSettings возвращает настройки нового подписчика; leadMinutes - NOTIFICATION_MINUTES
+func DefaultChatSettings(chatID int64, leadMinutes int) ChatSettings {
+	return ChatSettings{
+		ChatID:            chatID,
+		LeadMinutes:       leadMinutes,
+		MorningDigest:     true,
+		DistanceReminders: false,
+		ChangeAlerts:      true,
+	}
+}
+
+// Перед 3 парой (после большого перерыва) по умолчанию напоминаем
+// не меньше чем за 45 минут: в 12:15, если пара в 13:00
+const thirdLessonMinLead = 45
+
+// LeadTime возвращает, за сколько до начала пары нужно напомнить.
+// Минимум для 3 пары действует, только пока время не задано через /lead
+func (s ChatSettings) LeadTime(lesson *parser.Lesson) time.Duration {
+	minutes := s.LeadMinutes
+	if !s.LeadCustom && lesson.LessonNumber == "3" && minutes < thirdLessonMinLead {
+		minutes = thirdLessonMinLead
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// IsQuiet проверяет попадает ли момент t в тихие часы чата
+func (s ChatSettings) IsQuiet(t time.Time) bool {
+	if s.QuietStart == "" || s.QuietEnd == "" {
+		return false
+	}
+
+	start, err := parseClock(s.QuietStart)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(s.QuietEnd)
+	if err != nil {
+		return false
+	}
+
+	current := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return current >= start && current < end
+	}
+	// Интервал через полночь, например 23:00-08:00
+	return current >= start || current < end
+}
+
+// parseClock переводит "ЧЧ:ММ" в минуты от начала суток
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("неверное время %q, нужен формат ЧЧ:ММ", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// SubscriberStore хранит подписчиков и их настройки в JSON файле
+type SubscriberStore struct {
+	mu          sync.Mutex
+	filename    string
+	chats       map[int64]*ChatSettings
+	defaultLead int // Время напоминания для новых подписчиков
+}
+
+// NewSubscriberStore создает хранилище подписчиков поверх файла filename
+func NewSubscriberStore(filename string) *SubscriberStore {
+	return &SubscriberStore{
+		filename:    filename,
+		chats:       make(map[int64]*ChatSettings),
+		defaultLead: DefaultConfig().NotificationMinutes,
+	}
+}
+
+// SetDefaultLeadMinutes задает время напоминания для новых подписчиков
+func (s *SubscriberStore) SetDefaultLeadMinutes(minutes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultLead = minutes
+}
+
+// Load читает подписчиков из файла. Отсутствующий файл - не ошибка
+func (s *SubscriberStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("ошибка парсинга %s: %w", s.filename, err)
+	}
+
+	// Настройки, которых еще не было в файле, получают значения по умолчанию
+	s.chats = make(map[int64]*ChatSettings, len(list))
+	for _, raw := range list {
+		settings := DefaultChatSettings(0, s.defaultLead)
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return fmt.Errorf("ошибка парсинга %s: %w", s.filename, err)
+		}
+		s.chats[settings.ChatID] = &settings
+	}
+	return nil
+}
+
+// save сохраняет подписчиков на диск. Вызывается под s.mu
+func (s *SubscriberStore) save() error {
+	data, err := json.MarshalIndent(s.list(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.filename, data, 0644)
+}
+
+// list возвращает копию настроек, отсортированную по ID чата. Вызывается под s.mu
+func (s *SubscriberStore) list() []ChatSettings {
+	list := make([]ChatSettings, 0, len(s.chats))
+	for _, chat := range s.chats {
+		list = append(list, *chat)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ChatID < list[j].ChatID })
+	return list
+}
+
+// All возвращает настройки всех подписчиков
+func (s *SubscriberStore) All() []ChatSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list()
+}
+
+// Get возвращает настройки чата
+func (s *SubscriberStore) Get(chatID int64) (ChatSettings, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.chats[chatID]
+	if !ok {
+		return ChatSettings{}, false
+	}
+	return *chat, true
+}
+
+// FindByFeedToken ищет подписчика по токену личного календаря
+func (s *SubscriberStore) FindByFeedToken(token string) (ChatSettings, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token == "" {
+		return ChatSettings{}, false
+	}
+	for _, chat := range s.chats {
+		if subtle.ConstantTimeCompare([]byte(chat.FeedToken), []byte(token)) == 1 {
+			return *chat, true
+		}
+	}
+	return ChatSettings{}, false
+}
+
+// Subscribe добавляет чат с настройками по умолчанию, если его еще нет
+func (s *SubscriberStore) Subscribe(chatID int64) (ChatSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if chat, ok := s.chats[chatID]; ok {
+		return *chat, nil
+	}
+
+	settings := DefaultChatSettings(chatID, s.defaultLead)
+	s.chats[chatID] = &settings
+	return settings, s.save()
+}
+
+// Unsubscribe удаляет чат из подписчиков
+func (s *SubscriberStore) Unsubscribe(chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.chats[chatID]; !ok {
+		return nil
+	}
+	delete(s.chats, chatID)
+	return s.save()
+}
+
+// Update изменяет настройки подписанного чата и сохраняет их
+func (s *SubscriberStore) Update(chatID int64, change func(*ChatSettings)) (ChatSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.chats[chatID]
+	if !ok {
+		return ChatSettings{}, fmt.Errorf("чат %d не подписан", chatID)
+	}
+
+	change(chat)
+	return *chat, s.save()
+}
+
+// Migrate переносит настройки чата на новый ID: группа, ставшая
+// супергруппой, получает другой ID. Темы в обычной группе не было, поэтому
+// ThreadID сбрасывается
+func (s *SubscriberStore) Migrate(from, to int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat, ok := s.chats[from]
+	if !ok {
+		return nil
+	}
+	delete(s.chats, from)
+	chat.ChatID = to
+	chat.ThreadID = 0
+	s.chats[to] = chat
+	return s.save()
+}
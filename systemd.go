@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify сообщает systemd о состоянии сервиса (протокол sd_notify):
+// READY=1 после запуска, WATCHDOG=1 пока бот жив, STOPPING=1 при остановке.
+// Вне systemd или без Type=notify NOTIFY_SOCKET не задан, и вызов ничего не делает
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// Адрес на "@" - абстрактный сокет Linux, net подставит нулевой байт сам
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval возвращает, как часто слать WATCHDOG=1, или 0, если
+// в юните не задан WatchdogSec. systemd советует слать вдвое чаще таймаута
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// Переменные наследуются дочерними процессами, watchdog относится только к главному
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
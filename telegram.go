@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTelegramAPIURL адрес Bot API по умолчанию
+const DefaultTelegramAPIURL = "https://api.telegram.org"
+
+type Update struct {
+	UpdateID int     `json:"update_id"`
+	Message  Message `json:"message"`
+}
+
+type Message struct {
+	MessageID       int    `json:"message_id"`
+	MessageThreadID int    `json:"message_thread_id,omitempty"` // Тема форума
+	IsTopicMessage  bool   `json:"is_topic_message,omitempty"`
+	From            *User  `json:"from,omitempty"`        // Нет у сообщений каналов
+	SenderChat      *Chat  `json:"sender_chat,omitempty"` // Анонимный админ группы пишет от имени группы
+	Chat            Chat   `json:"chat"`
+	Text            string `json:"text"`
+}
+
+// SenderID возвращает ID отправителя, а без него - ID чата
+func (m Message) SenderID() int64 {
+	if m.From != nil {
+		return m.From.ID
+	}
+	return m.Chat.ID
+}
+
+type Chat struct {
+	ID      int64  `json:"id"`
+	Type    string `json:"type"` // private, group, supergroup или channel
+	Title   string `json:"title,omitempty"`
+	IsForum bool   `json:"is_forum,omitempty"` // Супергруппа с темами
+}
+
+// IsGroup проверяет, что чат - группа или супергруппа
+func (c Chat) IsGroup() bool {
+	return c.Type == "group" || c.Type == "supergroup"
+}
+
+// ChatMember участник чата из getChatMember
+type ChatMember struct {
+	Status string `json:"status"` // creator, administrator, member, restricted, left, kicked
+	User   User   `json:"user"`
+}
+
+// IsAdmin проверяет, что участник - владелец или администратор чата
+func (m ChatMember) IsAdmin() bool {
+	return m.Status == "creator" || m.Status == "administrator"
+}
+
+type User struct {
+	ID       int64  `json:"id"`
+	IsBot    bool   `json:"is_bot"`
+	Username string `json:"username"`
+}
+
+// SendMessageRequest параметры sendMessage
+type SendMessageRequest struct {
+	ChatID          int64
+	MessageThreadID int // Тема форума, 0 - общая
+	Text            string
+	ParseMode       string
+	ReplyTo         int // Ответ на сообщение с этим ID, если оно еще есть
+}
+
+// EditMessageTextRequest параметры editMessageText
+type EditMessageTextRequest struct {
+	ChatID    int64
+	MessageID int
+	Text      string
+	ParseMode string
+}
+
+// GetUpdatesRequest параметры getUpdates
+type GetUpdatesRequest struct {
+	Offset         int
+	Timeout        int // Секунды long polling
+	Limit          int // Не больше стольких обновлений, 0 - по умолчанию (100)
+	AllowedUpdates []string
+}
+
+// SetWebhookRequest параметры setWebhook
+type SetWebhookRequest struct {
+	URL            string
+	SecretToken    string
+	AllowedUpdates []string
+}
+
+// APIError ошибка, которую вернул Bot API (ok=false)
+type APIError struct {
+	Method      string
+	Code        int
+	Description string
+	RetryAfter  int // Секунды, для 429 Too Many Requests
+
+	// Группа стала супергруппой, писать нужно в чат с новым ID
+	MigrateToChatID int64
+}
+
+func (e *APIError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("telegram %s: %d %s (retry after %ds)", e.Method, e.Code, e.Description, e.RetryAfter)
+	}
+	return fmt.Sprintf("telegram %s: %d %s", e.Method, e.Code, e.Description)
+}
+
+// apiResponse общий конверт ответа Bot API
+type apiResponse struct {
+	Ok          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	Description string          `json:"description"`
+	ErrorCode   int             `json:"error_code"`
+	Parameters  struct {
+		RetryAfter      int   `json:"retry_after"`
+		MigrateToChatID int64 `json:"migrate_to_chat_id"`
+	} `json:"parameters"`
+}
+
+// TelegramClient минимальный клиент Telegram Bot API.
+// Базовый адрес и http.Client настраиваются, чтобы бота можно было
+// запускать против локального Bot API сервера или тестовой заглушки
+type TelegramClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	health TelegramHealth
+}
+
+// TelegramHealth результат последних запросов к Bot API для /readyz и /status
+type TelegramHealth struct {
+	LastOK      time.Time // Последний успешный запрос
+	LastError   error
+	LastErrorAt time.Time
+	Reachable   bool // Последний запрос дошел до Telegram и не упал на его стороне
+}
+
+// NewTelegramClient создает клиент. Пустой baseURL означает api.telegram.org,
+// nil httpClient - клиент с таймаутом, достаточным для long polling
+func NewTelegramClient(baseURL, token string, httpClient *http.Client) *TelegramClient {
+	if baseURL == "" {
+		baseURL = DefaultTelegramAPIURL
+	}
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 60 * time.Second}
+	}
+
+	return &TelegramClient{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+// call вызывает метод Bot API и декодирует result в result (если он не nil).
+// Отмена ctx прерывает запрос, в том числе ожидающий long polling
+func (c *TelegramClient) call(ctx context.Context, method string, params url.Values, result interface{}) (err error) {
+	endpoint := fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.token, method)
+
+	started := time.Now()
+	status := "error"
+	defer func() {
+		metricTelegramDuration.Observe(time.Since(started), method)
+		metricTelegramRequests.Inc(method, status)
+		// Прерванный при остановке запрос ничего не говорит о Telegram
+		if ctx.Err() == nil {
+			c.record(err)
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Не показываем токен из URL в логах
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var response apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("telegram %s: ошибка декодирования ответа (статус %d): %w", method, resp.StatusCode, err)
+	}
+
+	if !response.Ok {
+		code := response.ErrorCode
+		if code == 0 {
+			code = resp.StatusCode
+		}
+		status = strconv.Itoa(code)
+		if code == 429 {
+			metricTelegramRateLimited.Inc(method)
+		}
+		return &APIError{
+			Method:          method,
+			Code:            code,
+			Description:     response.Description,
+			RetryAfter:      response.Parameters.RetryAfter,
+			MigrateToChatID: response.Parameters.MigrateToChatID,
+		}
+	}
+
+	status = "ok"
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("telegram %s: ошибка декодирования result: %w", method, err)
+	}
+	return nil
+}
+
+// record запоминает результат запроса. Ответ Telegram с ошибкой в запросе
+// (400, 403, 429) значит, что Bot API доступен; 401 - что токен не работает
+func (c *TelegramClient) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err == nil {
+		c.health.LastOK = time.Now()
+		c.health.Reachable = true
+		return
+	}
+	c.health.LastError = err
+	c.health.LastErrorAt = time.Now()
+	var apiErr *APIError
+	c.health.Reachable = errors.As(err, &apiErr) && apiErr.Code < 500 && apiErr.Code != 401
+}
+
+// Health возвращает результат последних запросов
+func (c *TelegramClient) Health() TelegramHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.health
+}
+
+// SendMessage отправляет сообщение и возвращает его
+func (c *TelegramClient) SendMessage(ctx context.Context, req SendMessageRequest) (Message, error) {
+	params := url.Values{}
+	params.Set("chat_id", strconv.FormatInt(req.ChatID, 10))
+	if req.MessageThreadID != 0 {
+		params.Set("message_thread_id", strconv.Itoa(req.MessageThreadID))
+	}
+	params.Set("text", req.Text)
+	if req.ParseMode != "" {
+		params.Set("parse_mode", req.ParseMode)
+	}
+	if req.ReplyTo != 0 {
+		reply, _ := json.Marshal(map[string]interface{}{
+			"message_id":                  req.ReplyTo,
+			"allow_sending_without_reply": true,
+		})
+		params.Set("reply_parameters", string(reply))
+	}
+
+	var message Message
+	err := c.call(ctx, "sendMessage", params, &message)
+	return message, err
+}
+
+// EditMessageText заменяет текст отправленного ботом сообщения
+func (c *TelegramClient) EditMessageText(ctx context.Context, req EditMessageTextRequest) error {
+	params := url.Values{}
+	params.Set("chat_id", strconv.FormatInt(req.ChatID, 10))
+	params.Set("message_id", strconv.Itoa(req.MessageID))
+	params.Set("text", req.Text)
+	if req.ParseMode != "" {
+		params.Set("parse_mode", req.ParseMode)
+	}
+
+	return c.call(ctx, "editMessageText", params, nil)
+}
+
+// GetUpdates получает новые обновления (long polling)
+func (c *TelegramClient) GetUpdates(ctx context.Context, req GetUpdatesRequest) ([]Update, error) {
+	params := url.Values{}
+	params.Set("offset", strconv.Itoa(req.Offset))
+	params.Set("timeout", strconv.Itoa(req.Timeout))
+	if req.Limit > 0 {
+		params.Set("limit", strconv.Itoa(req.Limit))
+	}
+	if len(req.AllowedUpdates) > 0 {
+		params.Set("allowed_updates", encodeJSONList(req.AllowedUpdates))
+	}
+
+	var updates []Update
+	err := c.call(ctx, "getUpdates", params, &updates)
+	return updates, err
+}
+
+// SetWebhook включает доставку обновлений на webhook
+func (c *TelegramClient) SetWebhook(ctx context.Context, req SetWebhookRequest) error {
+	params := url.Values{}
+	params.Set("url", req.URL)
+	if req.SecretToken != "" {
+		params.Set("secret_token", req.SecretToken)
+	}
+	if len(req.AllowedUpdates) > 0 {
+		params.Set("allowed_updates", encodeJSONList(req.AllowedUpdates))
+	}
+
+	return c.call(ctx, "setWebhook", params, nil)
+}
+
+// DeleteWebhook отключает webhook, чтобы снова работал getUpdates
+func (c *TelegramClient) DeleteWebhook(ctx context.Context) error {
+	return c.call(ctx, "deleteWebhook", url.Values{}, nil)
+}
+
+// GetMe возвращает информацию о боте
+func (c *TelegramClient) GetMe(ctx context.Context) (User, error) {
+	var user User
+	err := c.call(ctx, "getMe", url.Values{}, &user)
+	return user, err
+}
+
+// GetChatMember возвращает статус пользователя в чате
+func (c *TelegramClient) GetChatMember(ctx context.Context, chatID, userID int64) (ChatMember, error) {
+	params := url.Values{}
+	params.Set("chat_id", strconv.FormatInt(chatID, 10))
+	params.Set("user_id", strconv.FormatInt(userID, 10))
+
+	var member ChatMember
+	err := c.call(ctx, "getChatMember", params, &member)
+	return member, err
+}
+
+func encodeJSONList(values []string) string {
+	data, _ := json.Marshal(values)
+	return string(data)
+}
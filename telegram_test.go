@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testToken = "123:secret-token"
+
+// fakeCall запрос к заглушке Bot API
+type fakeCall struct {
+	Method string
+	Params url.Values
+}
+
+// fakeReply ответ заглушки: HTTP статус и тело
+type fakeReply struct {
+	Status int
+	Body   interface{}
+}
+
+// fakeBotAPI заглушка Telegram Bot API поверх httptest. По умолчанию
+// отвечает успехом; respond может подменить ответ на любой запрос
+type fakeBotAPI struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	calls     []fakeCall
+	updates   []Update // Отдаются одним ответом getUpdates
+	messageID int
+	respond   func(call fakeCall) *fakeReply // nil ответ - поведение по умолчанию; вызывается под mu
+}
+
+func newFakeBotAPI(t *testing.T) *fakeBotAPI {
+	api := &fakeBotAPI{t: t, messageID: 100}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+// client возвращает клиент, настроенный на заглушку
+func (api *fakeBotAPI) client() *TelegramClient {
+	return NewTelegramClient(api.server.URL, testToken, nil)
+}
+
+// setRespond подменяет ответы заглушки
+func (api *fakeBotAPI) setRespond(respond func(call fakeCall) *fakeReply) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.respond = respond
+}
+
+// callsTo возвращает запросы к методу method в порядке поступления
+func (api *fakeBotAPI) callsTo(method string) []fakeCall {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	var calls []fakeCall
+	for _, call := range api.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (api *fakeBotAPI) serve(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+testToken+"/")
+	if method == r.URL.Path {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": 404, "description": "Not Found"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		api.t.Errorf("%s: %v", method, err)
+	}
+	call := fakeCall{Method: method, Params: r.PostForm}
+
+	// respond вызывается под api.mu, поэтому может без блокировок менять
+	// свое состояние, но не должен обращаться к методам заглушки
+	api.mu.Lock()
+	api.calls = append(api.calls, call)
+	var reply *fakeReply
+	if api.respond != nil {
+		reply = api.respond(call)
+	}
+	api.mu.Unlock()
+
+	if reply == nil {
+		reply = api.defaultReply(call)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reply.Status)
+	json.NewEncoder(w).Encode(reply.Body)
+}
+
+func (api *fakeBotAPI) defaultReply(call fakeCall) *fakeReply {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	var result interface{} = true
+	switch call.Method {
+	case "sendMessage":
+		api.messageID++
+		chatID, _ := strconv.ParseInt(call.Params.Get("chat_id"), 10, 64)
+		result = Message{MessageID: api.messageID, Chat: Chat{ID: chatID}, Text: call.Params.Get("text")}
+	case "getMe":
+		result = User{ID: 1, IsBot: true, Username: "msu_bot"}
+	case "getUpdates":
+		result = api.updates
+		if api.updates == nil {
+			result = []Update{}
+		}
+		api.updates = nil
+	case "getChatMember":
+		result = ChatMember{Status: "member"}
+	}
+	return apiOK(result)
+}
+
+// apiOK успешный ответ Bot API
+func apiOK(result interface{}) *fakeReply {
+	return &fakeReply{Status: http.StatusOK, Body: map[string]interface{}{"ok": true, "result": result}}
+}
+
+// apiFail ответ Bot API с ошибкой и необязательными parameters
+func apiFail(code int, description string, parameters map[string]interface{}) *fakeReply {
+	body := map[string]interface{}{"ok": false, "error_code": code, "description": description}
+	if parameters != nil {
+		body["parameters"] = parameters
+	}
+	return &fakeReply{Status: code, Body: body}
+}
+
+func TestTelegramCallDecodesResult(t *testing.T) {
+	api := newFakeBotAPI(t)
+	client := api.client()
+
+	sent, err := client.SendMessage(context.Background(), SendMessageRequest{ChatID: 42, Text: "<b>Пара</b>", ParseMode: "HTML", ReplyTo: 7})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if sent.MessageID != 101 || sent.Chat.ID != 42 {
+		t.Errorf("ответ разобран неверно: %+v", sent)
+	}
+
+	params := api.callsTo("sendMessage")[0].Params
+	if params.Get("chat_id") != "42" || params.Get("parse_mode") != "HTML" || params.Get("text") != "<b>Пара</b>" {
+		t.Errorf("параметры запроса: %v", params)
+	}
+	if !strings.Contains(params.Get("reply_parameters"), `"message_id":7`) {
+		t.Errorf("reply_parameters: %q", params.Get("reply_parameters"))
+	}
+	if health := client.Health(); !health.Reachable || health.LastOK.IsZero() {
+		t.Errorf("успешный запрос не учтен в Health: %+v", health)
+	}
+}
+
+func TestTelegramCallErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		reply     *fakeReply
+		want      APIError
+		reachable bool
+	}{
+		{
+			name:      "ok=false",
+			reply:     apiFail(400, "Bad Request: message text is empty", nil),
+			want:      APIError{Method: "sendMessage", Code: 400, Description: "Bad Request: message text is empty"},
+			reachable: true,
+		},
+		{
+			name:      "retry_after",
+			reply:     apiFail(429, "Too Many Requests: retry after 5", map[string]interface{}{"retry_after": 5}),
+			want:      APIError{Method: "sendMessage", Code: 429, Description: "Too Many Requests: retry after 5", RetryAfter: 5},
+			reachable: true,
+		},
+		{
+			name: "migrate_to_chat_id",
+			reply: apiFail(400, "Bad Request: group chat was upgraded to a supergroup chat",
+				map[string]interface{}{"migrate_to_chat_id": int64(-1001234567890)}),
+			want: APIError{Method: "sendMessage", Code: 400, Description: "Bad Request: group chat was upgraded to a supergroup chat",
+				MigrateToChatID: -1001234567890},
+			reachable: true,
+		},
+		{
+			name:  "без error_code берется HTTP статус",
+			reply: &fakeReply{Status: http.StatusBadGateway, Body: map[string]interface{}{"ok": false, "description": "Bad Gateway"}},
+			want:  APIError{Method: "sendMessage", Code: 502, Description: "Bad Gateway"},
+		},
+		{
+			name:  "неверный токен",
+			reply: apiFail(401, "Unauthorized", nil),
+			want:  APIError{Method: "sendMessage", Code: 401, Description: "Unauthorized"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newFakeBotAPI(t)
+			api.setRespond(func(fakeCall) *fakeReply { return tt.reply })
+			client := api.client()
+
+			_, err := client.SendMessage(context.Background(), SendMessageRequest{ChatID: 42, Text: "текст"})
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("ожидалась APIError, получено %v", err)
+			}
+			if *apiErr != tt.want {
+				t.Errorf("APIError = %+v, ожидалось %+v", *apiErr, tt.want)
+			}
+			if health := client.Health(); health.Reachable != tt.reachable {
+				t.Errorf("Reachable = %v, ожидалось %v", health.Reachable, tt.reachable)
+			}
+		})
+	}
+}
+
+func TestTelegramCallBadResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>502 Bad Gateway</html>"))
+	}))
+	defer server.Close()
+
+	_, err := NewTelegramClient(server.URL, testToken, nil).GetMe(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "статус 502") {
+		t.Fatalf("ожидалась ошибка декодирования со статусом, получено %v", err)
+	}
+}
+
+func TestTelegramCallHidesToken(t *testing.T) {
+	api := newFakeBotAPI(t)
+	client := api.client()
+	api.server.Close()
+
+	_, err := client.GetMe(context.Background())
+	if err == nil {
+		t.Fatal("запрос к остановленному серверу прошел")
+	}
+	if strings.Contains(err.Error(), testToken) {
+		t.Errorf("токен попал в текст ошибки: %v", err)
+	}
+	if client.Health().Reachable {
+		t.Error("недоступный Bot API отмечен как доступный")
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version версия сборки, задается при сборке (make build делает это сам):
+//
+//	go build -ldflags "-X main.Version=v1.2.0" .
+var Version = "dev"
+
+// BuildInfo сведения о сборке для /status, /metrics и msuparser version
+type BuildInfo struct {
+	Version   string
+	Commit    string // Пусто, если собрано не из git
+	CommitAt  string
+	Modified  bool // В рабочем каталоге были незакоммиченные изменения
+	GoVersion string
+}
+
+// buildInfo собирает сведения о сборке. Коммит и его время Go сам
+// записывает в бинарник при сборке из git-репозитория
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: Version, GoVersion: runtime.Version()}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Commit = setting.Value
+			if len(info.Commit) > 12 {
+				info.Commit = info.Commit[:12]
+			}
+		case "vcs.time":
+			info.CommitAt = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// String выводит "v1.2.0 (3f2a9c1b7d4e+, 2026-10-19T12:00:00Z, go1.24.0)"
+func (b BuildInfo) String() string {
+	text := b.Version
+	if b.Commit != "" {
+		commit := b.Commit
+		if b.Modified {
+			commit += "+"
+		}
+		text += fmt.Sprintf(" (%s, %s, %s)", commit, b.CommitAt, b.GoVersion)
+	} else {
+		text += " (" + b.GoVersion + ")"
+	}
+	return text
+}
+
+// runVersion печатает версию сборки
+func runVersion(args []string) {
+	fmt.Println("msuparser " + buildInfo().String())
+}
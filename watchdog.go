@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"msuparser/parser"
+)
+
+const DefaultStaleAfter = 48 * time.Hour
+
+// FetchStatusFile итог последней загрузки расписания. Его пишет и команда
+// fetch из таймера systemd, и сам бот, поэтому бот видит неудачи обоих
+const FetchStatusFile = "fetch_status.json"
+
+// Типы ошибок обновления. Админ получает сообщение, когда тип меняется,
+// а не на каждую неудачную попытку
+const (
+	FetchErrorNetwork    = "network"
+	FetchErrorValidation = "validation"
+	FetchErrorOther      = "other"
+)
+
+var fetchErrorNames = map[string]string{
+	FetchErrorNetwork:    "сеть",
+	parser.StepForm:      "страница формы",
+	parser.StepSchedule:  "запрос расписания",
+	parser.StepParse:     "разбор страницы",
+	FetchErrorValidation: "проверка расписания",
+	FetchErrorOther:      "другое",
+}
+
+// fetchErrorKind определяет тип ошибки обновления расписания
+func fetchErrorKind(err error) string {
+	var netErr net.Error
+	var stepErr *parser.StepError
+	switch {
+	case errors.As(err, &netErr):
+		return FetchErrorNetwork
+	case errors.Is(err, parser.ErrInvalidSchedule):
+		return FetchErrorValidation
+	case errors.As(err, &stepErr):
+		return stepErr.Step
+	}
+	return FetchErrorOther
+}
+
+// FetchHealth следит за обновлениями расписания: когда была последняя
+// попытка, сколько неудач подряд и о чем уже сообщили админу
+type FetchHealth struct {
+	mu           sync.Mutex
+	staleAfter   time.Duration
+	lastAttempt  time.Time
+	failures     int
+	lastError    error
+	lastKind     string
+	staleAlerted bool
+}
+
+// FetchStatus снимок FetchHealth для /status
+type FetchStatus struct {
+	StaleAfter  time.Duration
+	LastAttempt time.Time
+	Failures    int
+	LastError   error
+	LastKind    string
+}
+
+// NewFetchHealth создает наблюдатель; расписание старше staleAfter считается устаревшим
+func NewFetchHealth(staleAfter time.Duration) *FetchHealth {
+	return &FetchHealth{staleAfter: staleAfter}
+}
+
+// SetStaleAfter меняет порог устаревания, не сбрасывая счетчики
+func (h *FetchHealth) SetStaleAfter(staleAfter time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.staleAfter = staleAfter
+}
+
+// Status возвращает текущее состояние обновлений
+func (h *FetchHealth) Status() FetchStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return FetchStatus{
+		StaleAfter:  h.staleAfter,
+		LastAttempt: h.lastAttempt,
+		Failures:    h.failures,
+		LastError:   h.lastError,
+		LastKind:    h.lastKind,
+	}
+}
+
+// fetchRecord содержимое fetch_status.json
+type fetchRecord struct {
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind,omitempty"` // Тип ошибки, пусто - успех
+	Error    string    `json:"error,omitempty"`
+	Failures int       `json:"failures"` // Неудач подряд, включая эту
+}
+
+// readFetchRecord читает итог последней загрузки
+func readFetchRecord(filename string) (fetchRecord, error) {
+	var record fetchRecord
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return record, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, fmt.Errorf("ошибка парсинга %s: %w", filename, err)
+	}
+	return record, nil
+}
+
+// saveFetchRecord записывает итог загрузки; неудачи подряд считаются от
+// предыдущей записи в файле
+func saveFetchRecord(filename string, at time.Time, err error) error {
+	record := fetchRecord{Time: at}
+	if err != nil {
+		previous, _ := readFetchRecord(filename)
+		record.Kind = fetchErrorKind(err)
+		record.Error = err.Error()
+		record.Failures = previous.Failures + 1
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filename, data, 0644)
+}
+
+// loadFetchStatus при запуске берет из fetch_status.json последнюю попытку
+// и число неудач подряд, не сообщая админу заново о старой ошибке
+func (bot *TimetableBot) loadFetchStatus() {
+	bot.updateMu.Lock()
+	bot.fetchStatusWatcher = newFileWatcher(FetchStatusFile)
+	bot.updateMu.Unlock()
+
+	record, err := readFetchRecord(FetchStatusFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Warn("⚠️ Ошибка загрузки итога обновления", "file", FetchStatusFile, "err", err)
+		return
+	}
+
+	h := bot.fetchHealth
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastAttempt = record.Time
+	h.failures = record.Failures
+	h.lastKind = record.Kind
+	h.lastError = nil
+	if record.Kind != "" {
+		h.lastError = errors.New(record.Error)
+	}
+}
+
+// reloadFetchStatus учитывает попытку обновления, которую сделала команда
+// fetch. Вызывается под bot.updateMu
+func (bot *TimetableBot) reloadFetchStatus() {
+	record, err := readFetchRecord(FetchStatusFile)
+	if err != nil {
+		slog.Warn("⚠️ Файл итога обновления изменился, но не загружен", "file", FetchStatusFile, "err", err)
+		return
+	}
+
+	if record.Kind == "" {
+		slog.Info("🔄 Команда fetch обновила расписание", "at", record.Time)
+		bot.recordFetchResult(record.Time, "", nil)
+		return
+	}
+	err = errors.New(record.Error)
+	slog.Warn("⚠️ Команда fetch не смогла обновить расписание", "at", record.Time, "step", record.Kind, "err", err)
+	bot.recordFetchResult(record.Time, record.Kind, err)
+	bot.errors.Record(SubsystemFetch, err)
+}
+
+// saveFetchStatus записывает итог обновления ботом в fetch_status.json.
+// Вызывается под bot.updateMu
+func (bot *TimetableBot) saveFetchStatus(err error) {
+	if err := saveFetchRecord(FetchStatusFile, bot.clock.Now(), err); err != nil {
+		slog.Warn("⚠️ Не удалось сохранить итог обновления", "file", FetchStatusFile, "err", err)
+		return
+	}
+	// Свою запись не считаем попыткой команды fetch
+	if bot.fetchStatusWatcher != nil {
+		bot.fetchStatusWatcher.Reset()
+	}
+}
+
+// recordFetch учитывает результат обновления и сообщает админу о смене типа ошибки
+func (bot *TimetableBot) recordFetch(err error) {
+	kind := ""
+	if err != nil {
+		kind = fetchErrorKind(err)
+	}
+	bot.recordFetchResult(bot.clock.Now(), kind, err)
+}
+
+// recordFetchResult учитывает попытку обновления в момент at с ошибкой err типа kind
+func (bot *TimetableBot) recordFetchResult(at time.Time, kind string, err error) {
+	h := bot.fetchHealth
+	h.mu.Lock()
+
+	h.lastAttempt = at
+
+	if err == nil {
+		metricScheduleUpdates.Inc("ok")
+		failures := h.failures
+		h.failures = 0
+		h.lastError = nil
+		h.lastKind = ""
+		h.mu.Unlock()
+
+		if failures > 0 {
+			bot.alertAdmin(fmt.Sprintf("✅ Расписание снова обновляется (до этого неудачных попыток подряд: %d)", failures))
+		}
+		return
+	}
+
+	metricScheduleUpdates.Inc(kind)
+	changed := kind != h.lastKind
+	h.failures++
+	h.lastError = err
+	h.lastKind = kind
+	failures := h.failures
+	h.mu.Unlock()
+
+	if changed {
+		bot.alertAdmin(fmt.Sprintf("⚠️ Не удалось обновить расписание (попытка %d подряд)\nТип: %s\n%s",
+			failures, fetchErrorNames[kind], err))
+	}
+}
+
+// checkStaleSchedule сообщает админу, если расписание давно не обновлялось.
+// Одно сообщение на каждый период устаревания
+func (bot *TimetableBot) checkStaleSchedule(now time.Time) {
+	fetchedAt := bot.ScheduleModTime()
+	if fetchedAt.IsZero() {
+		return
+	}
+	age := now.Sub(fetchedAt)
+
+	h := bot.fetchHealth
+	h.mu.Lock()
+	stale := age > h.staleAfter
+	notify := stale != h.staleAlerted
+	h.staleAlerted = stale
+	failures, lastError := h.failures, h.lastError
+	h.mu.Unlock()
+
+	if !notify {
+		return
+	}
+	if !stale {
+		bot.alertAdmin("✅ Расписание снова актуально")
+		return
+	}
+
+	text := fmt.Sprintf("⏰ Расписание устарело: последнее обновление %s назад (%s)",
+		formatAge(age), fetchedAt.In(bot.runtime().location).Format("02.01.2006 15:04"))
+	if lastError != nil {
+		text += fmt.Sprintf("\nНеудачных попыток подряд: %d, последняя ошибка: %s", failures, lastError)
+	}
+	bot.alertAdmin(text)
+}
+
+// alertAdmin пишет в лог и отправляет сообщение в админский чат, если он задан
+func (bot *TimetableBot) alertAdmin(text string) {
+	slog.Warn("🚨 " + text)
+	adminChatID := bot.runtime().adminChatID
+	if adminChatID == 0 {
+		return
+	}
+	if err := bot.SendMessageToChat(adminChatID, html.EscapeString(text)); err != nil {
+		slog.Warn("⚠️ Не удалось отправить сообщение админу", "chat_id", adminChatID, "err", err)
+	}
+}
+
+// formatAge выводит длительность в виде "2 д 5 ч" или "3 ч 15 мин"
+func formatAge(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%d д %d ч", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%d ч %d мин", hours, minutes)
+	}
+	return fmt.Sprintf("%d мин", minutes)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	UpdatesModePolling = "polling"
+	UpdatesModeWebhook = "webhook"
+
+	// Заголовок, в котором Telegram передает secret_token из setWebhook
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+	maxWebhookBodySize  = 1 << 20
+
+	// Сколько раз пробовать setWebhook при запуске, прежде чем сдаться
+	// и завершить бота: systemd перезапустит его и попробует снова
+	webhookSetAttempts   = 6
+	webhookSetMaxBackoff = time.Minute
+)
+
+// Telegram разрешает в secret_token только A-Z, a-z, 0-9, _ и -
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// WebhookSettings настройки приема обновлений через webhook
+type WebhookSettings struct {
+	URL      string // Публичный HTTPS адрес, на который Telegram шлет обновления
+	Listen   string // Адрес локального сервера, например ":8443"
+	Secret   string // Ожидаемое значение заголовка X-Telegram-Bot-Api-Secret-Token
+	CertFile string // Сертификат и ключ, если TLS терминирует сам бот, а не прокси
+	KeyFile  string
+}
+
+// Validate проверяет настройки webhook и при необходимости генерирует секрет
+func (w *WebhookSettings) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("WEBHOOK_URL должен быть HTTPS адресом, получено %q", w.URL)
+	}
+	if w.Listen == "" {
+		return fmt.Errorf("WEBHOOK_LISTEN не задан")
+	}
+	if (w.CertFile == "") != (w.KeyFile == "") {
+		return fmt.Errorf("WEBHOOK_CERT и WEBHOOK_KEY нужно указывать вместе")
+	}
+
+	if w.Secret == "" {
+		// Webhook переустанавливается при каждом запуске, поэтому случайного секрета достаточно
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return fmt.Errorf("не удалось сгенерировать WEBHOOK_SECRET: %w", err)
+		}
+		w.Secret = hex.EncodeToString(buf)
+	}
+	if !webhookSecretPattern.MatchString(w.Secret) {
+		return fmt.Errorf("WEBHOOK_SECRET может содержать только A-Z, a-z, 0-9, _ и - (до 256 символов)")
+	}
+
+	return nil
+}
+
+// WebhookHandler принимает обновления от Telegram и передает их в HandleUpdate
+func (bot *TimetableBot) WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		secret := r.Header.Get(webhookSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(bot.webhook.Secret)) != 1 {
+			slog.Warn("⚠️ Webhook: неверный secret token", "remote_addr", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var update Update
+		body := http.MaxBytesReader(w, r.Body, maxWebhookBodySize)
+		if err := json.NewDecoder(body).Decode(&update); err != nil {
+			slog.Warn("⚠️ Webhook: ошибка декодирования обновления", "err", err)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		bot.HandleUpdate(update)
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// ServeWebhook регистрирует webhook в Telegram и обслуживает его до отмены ctx.
+// Возвращает ошибку, если webhook не удалось зарегистрировать или сервер
+// не удалось запустить
+func (bot *TimetableBot) ServeWebhook(ctx context.Context) error {
+	if err := bot.setWebhookWithRetry(ctx); err != nil {
+		return err
+	}
+
+	path := "/"
+	if u, err := url.Parse(bot.webhook.URL); err == nil && u.Path != "" {
+		path = u.Path
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(path, bot.WebhookHandler())
+	if path != "/" {
+		mux.Handle("/", http.NotFoundHandler())
+	}
+
+	server := &http.Server{
+		Addr:              bot.webhook.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+	}
+
+	slog.Info("🌐 Webhook сервер запущен", "listen", bot.webhook.Listen, "path", path)
+	// Webhook в Telegram при остановке не удаляем: обновления подождут
+	// на стороне Telegram и придут после перезапуска
+	return serveHTTP(ctx, server, func() error {
+		if bot.webhook.CertFile != "" {
+			return server.ListenAndServeTLS(bot.webhook.CertFile, bot.webhook.KeyFile)
+		}
+		return server.ListenAndServe()
+	})
+}
+
+// setWebhookWithRetry регистрирует webhook, повторяя попытки с
+// экспоненциальной задержкой или через retry_after: при запуске сеть или
+// Bot API могут быть еще недоступны
+func (bot *TimetableBot) setWebhookWithRetry(ctx context.Context) error {
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err := bot.SetWebhook(ctx)
+		if err == nil || ctx.Err() != nil || attempt >= webhookSetAttempts {
+			return err
+		}
+
+		wait := delay
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			wait = time.Duration(apiErr.RetryAfter) * time.Second
+		}
+		slog.Warn("⚠️ Не удалось установить webhook, повторю позже", "attempt", attempt, "retry_in", wait, "err", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		delay *= 2
+		if delay > webhookSetMaxBackoff {
+			delay = webhookSetMaxBackoff
+		}
+	}
+}
+
+// SetWebhook сообщает Telegram адрес webhook и секрет
+func (bot *TimetableBot) SetWebhook(ctx context.Context) error {
+	return bot.telegram.SetWebhook(ctx, SetWebhookRequest{
+		URL:            bot.webhook.URL,
+		SecretToken:    bot.webhook.Secret,
+		AllowedUpdates: []string{"message"},
+	})
+}